@@ -31,7 +31,13 @@ func main() {
 		metrics.WithExporter(httpExporter),
 	)
 
-	if err := rootCmd.Execute(); err != nil {
+	err = rootCmd.Execute()
+
+	// Shut down the exporters once the process is done executing commands
+	if shutdownErr := rootCmd.Shutdown(context.Background()); shutdownErr != nil {
+		log.Println("Failed to shut down metrics:", shutdownErr)
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 
@@ -64,7 +65,13 @@ func main() {
 	rootCmd.AddCommand(subCmd)
 	rootCmd.AddCommand(anotherChildCmd)
 
-	if err := rootCmd.Execute(); err != nil {
+	err := rootCmd.Execute()
+
+	// Shut down the exporters once the process is done executing commands
+	if shutdownErr := rootCmd.Shutdown(context.Background()); shutdownErr != nil {
+		log.Println("Failed to shut down metrics:", shutdownErr)
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
 }
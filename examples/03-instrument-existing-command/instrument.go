@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	metrics "github.com/iamkirkbater/cobra-otel-metrics"
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+)
+
+// This example shows how to instrument an existing *cobra.Command, such as
+// one generated by cobra-cli, without replacing it with a metrics.Command.
+
+func main() {
+	rootCmd := newRootCmd()
+
+	stdoutExporter, err := stdoutmetric.New()
+	if err != nil {
+		log.Fatal("Failed to setup stdoutExporter:", err)
+	}
+
+	telemetry, err := metrics.Instrument(rootCmd,
+		metrics.WithExporter(stdoutExporter),
+	)
+	if err != nil {
+		log.Fatal("Failed to setup metrics:", err)
+	}
+
+	// Execute through the telemetry rather than the root command
+	// so metrics are exported once the command is done
+	err = telemetry.Execute()
+
+	// Shut down the exporters once the process is done executing commands
+	if shutdownErr := telemetry.Shutdown(context.Background()); shutdownErr != nil {
+		log.Println("Failed to shut down metrics:", shutdownErr)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func newRootCmd() *cobra.Command {
+	rootCmd := &cobra.Command{
+		Use:   "existing",
+		Short: "Example existing CLI application instrumented with OpenTelemetry metrics",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Usage()
+		},
+	}
+
+	rootCmd.AddCommand(&cobra.Command{
+		Use:  "hello",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Println("Hello World")
+		},
+	})
+
+	return rootCmd
+}
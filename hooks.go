@@ -0,0 +1,200 @@
+package metrics
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/iamkirkbater/cobra-otel-metrics/internal"
+	"github.com/spf13/cobra"
+)
+
+// wrapCommandTree wraps the hooks of every command in the tree that hasn't
+// been wrapped yet. This runs on every execution to pick up commands added
+// after the tree was instrumented.
+func (t *Telemetry) wrapCommandTree(cmd *cobra.Command) {
+	if !t.wrapped[cmd] {
+		t.wrapped[cmd] = true
+		t.wrapCommandHooks(cmd)
+	}
+	children := cmd.Commands()
+	for i := range children {
+		t.wrapCommandTree(children[i])
+	}
+}
+
+// wrapCommandHooks wraps all of the hooks we need on a single command. The
+// root command always gets a persistent pre-run hook so every invocation
+// records. Post-run hooks are left alone for cobra to run as it would.
+func (t *Telemetry) wrapCommandHooks(cmd *cobra.Command) {
+	t.wrapPreRun(cmd, !cmd.HasParent())
+	internal.WrapCmdErrorHooks(cmd, t.setErrorCategory)
+	// Disabled commands never record help invocations, however their
+	// subcommands may re-enable telemetry so we still walk them
+	if !internal.IsCommandTelemetryDisabled(cmd) {
+		t.wrapHelpFunc(cmd)
+	}
+}
+
+// wrapPreRun replaces the command's persistent pre-run hook with one that
+// records the invocation and then calls the original hook. Cobra only
+// calls PersistentPreRunE when both variants are set, so we do the same.
+func (t *Telemetry) wrapPreRun(cmd *cobra.Command, force bool) {
+	originalPreRunE := cmd.PersistentPreRunE
+	originalPreRun := cmd.PersistentPreRun
+	if originalPreRunE != nil || originalPreRun != nil || force {
+		cmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+			// With cobra.EnableTraverseRunHooks every wrapped hook from
+			// the root down runs, so only the first one records
+			if t.execution.active && !t.execution.invocationHandled {
+				t.execution.invocationHandled = true
+				t.execution.preRunAt = time.Now()
+				t.mu.Lock()
+				t.execution.invokedCmd = cmd
+				t.mu.Unlock()
+				// lets work done with the command's context, like
+				// requests through Transport, be attributed to it.
+				// Cobra keeps the context across repeated executions.
+				if invoked, ok := internal.CommandFromContext(cmd.Context()); !ok || invoked != cmd {
+					cmd.SetContext(internal.ContextWithCommand(cmd.Context(), cmd))
+				}
+				// create the initial metric around the command called
+				err := t.handleInvocation(cmd, args)
+				if err != nil {
+					return err
+				}
+			}
+
+			// Run the original hook if it exists
+			if originalPreRunE != nil {
+				return originalPreRunE(cmd, args)
+			}
+			if originalPreRun != nil {
+				originalPreRun(cmd, args)
+			}
+			return nil
+		}
+	}
+}
+
+// handleInvocation decides if metrics are collected for this invocation,
+// prompting for consent if needed, and records the invocation metric
+func (t *Telemetry) handleInvocation(cmd *cobra.Command, args []string) error {
+	// Shells request completions on every TAB, so these are never
+	// counted as invocations and never prompt for consent
+	if internal.IsCompletionRequest(cmd) {
+		return t.handleCompletion(cmd, args)
+	}
+
+	// Skip the prompt entirely if the user has disabled telemetry
+	// for just this invocation, or the command never records it
+	if t.isTelemetryDisabled(cmd) {
+		internal.UserHasOptedInForMetrics = false
+		return nil
+	}
+
+	// The wall clock is paused while the user decides on consent,
+	// so the prompt isn't counted as the invocation's duration
+	consentStarted := time.Now()
+	err := internal.HandleMetricsOptIn(cmd, t.provider.Config())
+	t.mu.Lock()
+	t.execution.startedAt = t.execution.startedAt.Add(time.Since(consentStarted))
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if !t.sample(cmd) {
+		return nil
+	}
+	err = t.createInvocationMetric(cmd)
+	if err != nil {
+		return err
+	}
+	err = t.createDeprecationMetric(cmd)
+	if err != nil {
+		return err
+	}
+	if internal.UserHasOptedInForMetrics && t.provider.Config().StartupMetrics {
+		err = t.createStartupMetrics(cmd)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Long running commands export periodically so their
+	// metrics aren't held until exit, or lost on a crash
+	if internal.UserHasOptedInForMetrics && internal.IsCommandLongRunning(cmd) {
+		t.startLongRunningExport()
+	}
+
+	// Commands can also be scraped while they run, rather than pushed
+	if internal.UserHasOptedInForMetrics && internal.IsCommandPrometheusEnabled(cmd) &&
+		t.provider.Config().PrometheusAddress != "" {
+		t.startPrometheusEndpoint()
+	}
+	return nil
+}
+
+// handleCompletion records the completion metric for a completion
+// request if enabled, without ever prompting for consent
+func (t *Telemetry) handleCompletion(cmd *cobra.Command, args []string) error {
+	target := internal.ParseCompletionTarget(cmd, args)
+	if !t.provider.Config().CompletionMetrics || t.isTelemetryDisabled(target) {
+		internal.UserHasOptedInForMetrics = false
+		return nil
+	}
+
+	internal.HandleMetricsOptInWithoutPrompt(cmd, t.provider.Config())
+	if !t.sample(target) {
+		return nil
+	}
+	return t.createCompletionMetric(target)
+}
+
+// sample decides once per invocation if an opted in invocation is
+// sampled, leaving it opted out for this invocation if it isn't. Only
+// invocations that are collected record anything from then on.
+func (t *Telemetry) sample(cmd *cobra.Command) bool {
+	if internal.UserHasOptedInForMetrics && !t.provider.Sample(cmd) {
+		internal.UserHasOptedInForMetrics = false
+	}
+	t.provider.SetRecording(internal.UserHasOptedInForMetrics)
+	return internal.UserHasOptedInForMetrics
+}
+
+// isTelemetryDisabled determines if telemetry is disabled for this
+// invocation by either the no-telemetry flag or a command annotation
+func (t *Telemetry) isTelemetryDisabled(cmd *cobra.Command) bool {
+	return internal.IsTelemetryDisabledByFlag(cmd, t.provider.Config()) ||
+		internal.IsCommandTelemetryDisabled(cmd)
+}
+
+// wrapHelpFunc records an invocation when help is shown, as the pre-run
+// hooks never run for it. Help is never a reason to prompt for consent.
+func (t *Telemetry) wrapHelpFunc(cmd *cobra.Command) {
+	oldHelpFunc := cmd.HelpFunc()
+	cmd.SetHelpFunc(func(cmd *cobra.Command, args []string) {
+		if t.execution.active && !t.execution.invocationHandled {
+			t.execution.invocationHandled = true
+			t.handleHelpInvocation(cmd)
+		}
+		oldHelpFunc(cmd, args)
+	})
+}
+
+// handleHelpInvocation decides if metrics are collected for help without
+// prompting for consent, and records the invocation metric
+func (t *Telemetry) handleHelpInvocation(cmd *cobra.Command) {
+	if t.isTelemetryDisabled(cmd) {
+		internal.UserHasOptedInForMetrics = false
+		return
+	}
+
+	internal.HandleMetricsOptInWithoutPrompt(cmd, t.provider.Config())
+	if !t.sample(cmd) {
+		return
+	}
+	if err := t.createInvocationMetric(cmd); err != nil {
+		fmt.Fprintf(os.Stderr, "error recording invocation metric: %v\n", err)
+	}
+}
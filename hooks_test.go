@@ -0,0 +1,242 @@
+package metrics
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+	metricSdk "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// recordingExporter keeps everything exported so tests can check
+// what each execution exported
+type recordingExporter struct {
+	mu      sync.Mutex
+	exports []metricdata.ResourceMetrics
+}
+
+func (e *recordingExporter) Temporality(kind metricSdk.InstrumentKind) metricdata.Temporality {
+	return metricdata.DeltaTemporality
+}
+
+func (e *recordingExporter) Aggregation(kind metricSdk.InstrumentKind) metricSdk.Aggregation {
+	return metricSdk.DefaultAggregationSelector(kind)
+}
+
+func (e *recordingExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.exports = append(e.exports, *rm)
+	return nil
+}
+
+func (e *recordingExporter) ForceFlush(ctx context.Context) error { return nil }
+
+func (e *recordingExporter) Shutdown(ctx context.Context) error { return nil }
+
+// Returns the data points of the counter in every export so far, and
+// forgets them so the next call only sees new exports
+func (e *recordingExporter) takeCounter(name string) []metricdata.DataPoint[int64] {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	points := []metricdata.DataPoint[int64]{}
+	for _, rm := range e.exports {
+		for _, scope := range rm.ScopeMetrics {
+			for _, m := range scope.Metrics {
+				if sum, ok := m.Data.(metricdata.Sum[int64]); ok && m.Name == name {
+					points = append(points, sum.DataPoints...)
+				}
+			}
+		}
+	}
+	e.exports = nil
+	return points
+}
+
+// Returns the data points of the histogram in every export so far
+func (e *recordingExporter) histogramPoints(name string) []metricdata.HistogramDataPoint[float64] {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	points := []metricdata.HistogramDataPoint[float64]{}
+	for _, rm := range e.exports {
+		for _, scope := range rm.ScopeMetrics {
+			for _, m := range scope.Metrics {
+				if histogram, ok := m.Data.(metricdata.Histogram[float64]); ok && m.Name == name {
+					points = append(points, histogram.DataPoints...)
+				}
+			}
+		}
+	}
+	return points
+}
+
+func sumPoints(points []metricdata.DataPoint[int64]) int64 {
+	total := int64(0)
+	for _, point := range points {
+		total += point.Value
+	}
+	return total
+}
+
+func attributeValue(point metricdata.DataPoint[int64], key string) (string, bool) {
+	value, ok := point.Attributes.Value(attribute.Key(key))
+	return value.Emit(), ok
+}
+
+// Gives the root command and a subcommand every persistent hook, which
+// append to calls as they run, and returns the subcommand
+func newHookTree(root *cobra.Command, calls *[]string, nonE bool) *cobra.Command {
+	hook := func(name string) (func(*cobra.Command, []string), func(*cobra.Command, []string) error) {
+		run := func(cmd *cobra.Command, args []string) { *calls = append(*calls, name) }
+		runE := func(cmd *cobra.Command, args []string) error { run(cmd, args); return nil }
+		return run, runE
+	}
+
+	sub := &cobra.Command{Use: "sub", Run: func(cmd *cobra.Command, args []string) { *calls = append(*calls, "sub run") }}
+	for _, c := range []*cobra.Command{root, sub} {
+		preRun, preRunE := hook(c.Name() + " pre-run")
+		postRun, postRunE := hook(c.Name() + " post-run")
+		if nonE {
+			c.PersistentPreRun, c.PersistentPostRun = preRun, postRun
+		} else {
+			c.PersistentPreRunE, c.PersistentPostRunE = preRunE, postRunE
+		}
+	}
+	root.AddCommand(sub)
+	root.SetIn(strings.NewReader(""))
+	return sub
+}
+
+// Builds an instrumented command with every persistent hook set, which
+// exports to the exporter
+func newInstrumentedHookTree(t *testing.T, calls *[]string, nonE bool, exporter *recordingExporter) (*Command, *cobra.Command) {
+	t.Helper()
+
+	c := &Command{Command: cobra.Command{Use: "hooks"}}
+	sub := newHookTree(&c.Command, calls, nonE)
+	if err := c.SetupMetrics(WithExporter(exporter)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return c, sub
+}
+
+func TestHooksRunOnceAndRecordOnce(t *testing.T) {
+	tests := []struct {
+		name     string
+		traverse bool
+		nonE     bool
+	}{
+		{name: "default", traverse: false},
+		{name: "traverse run hooks", traverse: true},
+		{name: "non-E hooks", traverse: false, nonE: true},
+		{name: "non-E hooks with traverse run hooks", traverse: true, nonE: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := cobra.EnableTraverseRunHooks
+			cobra.EnableTraverseRunHooks = tt.traverse
+			defer func() { cobra.EnableTraverseRunHooks = original }()
+
+			// the hooks cobra runs without any instrumentation
+			expected := []string{}
+			plain := &cobra.Command{Use: "hooks"}
+			newHookTree(plain, &expected, tt.nonE)
+			plain.SetArgs([]string{"sub"})
+			if err := plain.Execute(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			calls := []string{}
+			exporter := &recordingExporter{}
+			c, _ := newInstrumentedHookTree(t, &calls, tt.nonE, exporter)
+			c.SetArgs([]string{"sub"})
+			if err := c.Execute(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if !slices.Equal(calls, expected) {
+				t.Errorf("hooks ran %v, want %v", calls, expected)
+			}
+			if got := sumPoints(exporter.takeCounter("cli-hooks-invocations")); got != 1 {
+				t.Errorf("invocations = %d, want 1", got)
+			}
+		})
+	}
+}
+
+func TestWrappingIsIdempotent(t *testing.T) {
+	addLate := func(sub *cobra.Command, calls *[]string) {
+		late := &cobra.Command{Use: "late", Run: func(cmd *cobra.Command, args []string) { *calls = append(*calls, "late run") }}
+		late.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+			*calls = append(*calls, "late pre-run")
+			return nil
+		}
+		sub.AddCommand(late)
+	}
+
+	expected := []string{}
+	plain := &cobra.Command{Use: "hooks"}
+	addLate(newHookTree(plain, &expected, false), &expected)
+	plain.SetArgs([]string{"sub", "late"})
+	if err := plain.Execute(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	calls := []string{}
+	exporter := &recordingExporter{}
+	c, sub := newInstrumentedHookTree(t, &calls, false, exporter)
+
+	// Commands added after SetupMetrics are wrapped on the next execution,
+	// and wrapping again, as every execution does, never wraps twice
+	addLate(sub, &calls)
+	c.telemetry.wrapCommandTree(&c.Command)
+	c.telemetry.wrapCommandTree(&c.Command)
+
+	c.SetArgs([]string{"sub", "late"})
+	if err := c.ExecuteContext(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !slices.Equal(calls, expected) {
+		t.Errorf("hooks ran %v, want %v", calls, expected)
+	}
+	points := exporter.takeCounter("cli-hooks-invocations")
+	if len(points) != 1 || points[0].Value != 1 {
+		t.Fatalf("Expected 1 invocation of the late command, got %+v", points)
+	}
+	if command, _ := attributeValue(points[0], "command"); command != "sub-late" {
+		t.Errorf("Expected the late command to be recorded, got %q", command)
+	}
+}
+
+func TestDirectCobraExecutionSkipsInstrumentation(t *testing.T) {
+	calls := []string{}
+	exporter := &recordingExporter{}
+	c, _ := newInstrumentedHookTree(t, &calls, false, exporter)
+	defer c.Shutdown(context.Background())
+
+	c.Command.SetArgs([]string{"sub"})
+	if err := c.Command.Execute(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(calls) == 0 {
+		t.Fatalf("Expected the command's own hooks to still run")
+	}
+
+	// Nothing from the direct execution is held over for the next one
+	c.SetArgs([]string{"sub"})
+	if err := c.Execute(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := sumPoints(exporter.takeCounter("cli-hooks-invocations")); got != 1 {
+		t.Errorf("invocations = %d, want 1", got)
+	}
+}
@@ -0,0 +1,200 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/iamkirkbater/cobra-otel-metrics/internal"
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Creates the counter for a built-in metric, named by the configured
+// naming scheme
+func (t *Telemetry) builtinCounter(cmd *cobra.Command, m internal.BuiltinMetric) (metric.Int64Counter, error) {
+	definition := t.provider.Config().MetricDefinition(cmd, m)
+	counter, err := t.provider.GetMeter().Int64Counter(
+		definition.Name,
+		metric.WithDescription(definition.Description),
+		metric.WithUnit(definition.Unit),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create counter: %w", err)
+	}
+	return counter, nil
+}
+
+// Creates the histogram for a built-in metric, named by the configured
+// naming scheme
+func (t *Telemetry) builtinHistogram(cmd *cobra.Command, m internal.BuiltinMetric) (metric.Float64Histogram, error) {
+	definition := t.provider.Config().MetricDefinition(cmd, m)
+	histogram, err := t.provider.GetMeter().Float64Histogram(
+		definition.Name,
+		metric.WithDescription(definition.Description),
+		metric.WithUnit(definition.Unit),
+		metric.WithExplicitBucketBoundaries(internal.HistogramBuckets(m)...),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create histogram: %w", err)
+	}
+	return histogram, nil
+}
+
+func (t *Telemetry) createInvocationMetric(cmd *cobra.Command) error {
+	if internal.IsCommandTelemetryDisabled(cmd) {
+		return nil
+	}
+
+	// Create a counter metric
+	counter, err := t.builtinCounter(cmd, internal.MetricInvocations)
+	if err != nil {
+		return err
+	}
+
+	config := t.provider.Config()
+	attributes := config.FlagAttributes(cmd)
+	attributes = append(attributes, internal.ParseCmdAnnotationsToAttributes(cmd)...)
+	attributes = append(attributes, config.CommonAttributes(cmd)...)
+	attributes = append(attributes, config.AttributeKey(internal.AttributeTTY).Bool(internal.IsTTY()))
+	attributes = append(attributes, config.AttributeKey(internal.AttributeCommand).String(internal.ParseCmdName(cmd)))
+	attributes = append(attributes, config.AttributeKey(internal.AttributeInvokedAs).String(internal.ParseCmdInvokedAs(cmd, t.execution.args)))
+	if shorthandFlags := internal.ParseCmdShorthandFlags(cmd, t.execution.args); len(shorthandFlags) > 0 {
+		attributes = append(attributes, config.AttributeKey(internal.AttributeShorthandFlags).StringSlice(shorthandFlags))
+	}
+	if sessionID := config.SessionID; sessionID != "" {
+		attributes = append(attributes, config.AttributeKey(internal.AttributeSession).String(sessionID))
+	}
+
+	attributeSet, _ := attribute.NewSetWithFiltered(attributes, nil)
+
+	counter.Add(context.Background(), 1, metric.WithAttributeSet(attributeSet))
+
+	return nil
+}
+
+func (t *Telemetry) createDeprecationMetric(cmd *cobra.Command) error {
+	usages := internal.ParseCmdDeprecatedUsage(cmd, t.execution.args)
+	if len(usages) == 0 {
+		return nil
+	}
+
+	counter, err := t.builtinCounter(cmd, internal.MetricDeprecatedUsage)
+	if err != nil {
+		return err
+	}
+
+	config := t.provider.Config()
+	for _, usage := range usages {
+		attributes := config.CommonAttributes(cmd)
+		attributes = append(attributes,
+			config.AttributeKey(internal.AttributeKind).String(usage.Kind),
+			config.AttributeKey(internal.AttributeCommand).String(usage.Command),
+		)
+		if usage.Flag != "" {
+			attributes = append(attributes, config.AttributeKey(internal.AttributeFlag).String(usage.Flag))
+		}
+		counter.Add(context.Background(), 1, metric.WithAttributes(attributes...))
+	}
+
+	return nil
+}
+
+func (t *Telemetry) createErrorMetric(cmd *cobra.Command, category string) error {
+	counter, err := t.builtinCounter(cmd, internal.MetricErrors)
+	if err != nil {
+		return err
+	}
+
+	config := t.provider.Config()
+	attributes := config.CommonAttributes(cmd)
+	attributes = append(attributes,
+		config.AttributeKey(internal.AttributeCategory).String(category),
+		config.AttributeKey(internal.AttributeCommand).String(internal.ParseCmdName(cmd)),
+		config.AttributeKey(internal.AttributeTTY).Bool(internal.IsTTY()),
+	)
+	counter.Add(context.Background(), 1, metric.WithAttributes(attributes...))
+
+	return nil
+}
+
+func (t *Telemetry) createCompletionMetric(cmd *cobra.Command) error {
+	counter, err := t.builtinCounter(cmd, internal.MetricCompletions)
+	if err != nil {
+		return err
+	}
+
+	config := t.provider.Config()
+	attributes := config.CommonAttributes(cmd)
+	attributes = append(attributes,
+		config.AttributeKey(internal.AttributeCommand).String(internal.ParseCmdName(cmd)),
+	)
+	counter.Add(context.Background(), 1, metric.WithAttributes(attributes...))
+
+	return nil
+}
+
+func (t *Telemetry) createResourceMetrics(cmd *cobra.Command) error {
+	records := map[internal.BuiltinMetric]float64{
+		internal.MetricDuration: time.Since(t.execution.startedAt).Seconds(),
+	}
+	if usage, ok := internal.ReadResourceUsage(); ok {
+		usage = usage.Sub(t.execution.startUsage)
+		records[internal.MetricUserCPUTime] = usage.UserTime.Seconds()
+		records[internal.MetricSystemCPUTime] = usage.SystemTime.Seconds()
+		records[internal.MetricMaxRSS] = float64(usage.MaxRSS)
+	}
+
+	return t.recordHistograms(cmd, internal.ParseCmdName(cmd), records)
+}
+
+func (t *Telemetry) createRuntimeMetrics(cmd *cobra.Command, stats internal.RuntimeStats) error {
+	return t.recordHistograms(cmd, internal.ParseCmdName(cmd), map[internal.BuiltinMetric]float64{
+		internal.MetricGCPauses:      stats.GCPauses.Seconds(),
+		internal.MetricHeapAllocated: float64(stats.HeapAllocated),
+		internal.MetricGCCycles:      float64(stats.GCCycles),
+		internal.MetricGoroutines:    float64(stats.MaxGoroutines),
+	})
+}
+
+// Records the time from process start to the pre-run hook, and the exit
+// latency saved by the previous process, for the first execution only
+func (t *Telemetry) createStartupMetrics(cmd *cobra.Command) error {
+	if !t.execution.first {
+		return nil
+	}
+
+	err := t.recordHistograms(cmd, internal.ParseCmdName(cmd), map[internal.BuiltinMetric]float64{
+		internal.MetricStartupLatency: t.execution.preRunAt.Sub(internal.ProcessStartTime()).Seconds(),
+	})
+	if err != nil {
+		return err
+	}
+
+	// the previous invocation may have been a different command
+	if command, latency, ok := t.provider.Config().TakeExitLatency(); ok {
+		return t.recordHistograms(cmd, command, map[internal.BuiltinMetric]float64{
+			internal.MetricExitLatency: latency.Seconds(),
+		})
+	}
+	return nil
+}
+
+// Records a value for each of the built-in histograms, tagged with the command
+func (t *Telemetry) recordHistograms(cmd *cobra.Command, command string, records map[internal.BuiltinMetric]float64) error {
+	config := t.provider.Config()
+	attributes := config.CommonAttributes(cmd)
+	attributes = append(attributes, config.AttributeKey(internal.AttributeCommand).String(command))
+	options := metric.WithAttributes(attributes...)
+
+	for m, value := range records {
+		histogram, err := t.builtinHistogram(cmd, m)
+		if err != nil {
+			return err
+		}
+		histogram.Record(context.Background(), value, options)
+	}
+
+	return nil
+}
@@ -0,0 +1,478 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+const (
+	DefaultAggregationMaxSize = 1 << 20
+
+	defaultAggregateFilenamePostfix = "metrics-aggregate"
+)
+
+const (
+	aggregateKindSum       = "sum"
+	aggregateKindGauge     = "gauge"
+	aggregateKindHistogram = "histogram"
+)
+
+// localAggregate is the on-disk aggregate that every invocation's metrics
+// are merged into until it's rolled up and exported
+type localAggregate struct {
+	Start   time.Time          `json:"start"`
+	Metrics []*aggregateMetric `json:"metrics"`
+}
+
+type aggregateMetric struct {
+	Scope       string            `json:"scope"`
+	Version     string            `json:"version,omitempty"`
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Unit        string            `json:"unit,omitempty"`
+	Kind        string            `json:"kind"`
+	Float       bool              `json:"float,omitempty"`
+	Cumulative  bool              `json:"cumulative,omitempty"`
+	Monotonic   bool              `json:"monotonic,omitempty"`
+	Points      []*aggregatePoint `json:"points"`
+}
+
+// Values are kept as floats for both int and float instruments
+type aggregatePoint struct {
+	Attributes   []aggregateAttribute `json:"attributes"`
+	Value        float64              `json:"value,omitempty"`
+	Count        uint64               `json:"count,omitempty"`
+	Bounds       []float64            `json:"bounds,omitempty"`
+	BucketCounts []uint64             `json:"bucket_counts,omitempty"`
+	Min          *float64             `json:"min,omitempty"`
+	Max          *float64             `json:"max,omitempty"`
+	Sum          float64              `json:"sum,omitempty"`
+
+	// The last value merged from each cumulative stream, so only what's
+	// new since then is added
+	Baselines []*aggregateBaseline `json:"baselines,omitempty"`
+
+	key string
+}
+
+// Each process starts its own cumulative streams, which are told apart by
+// their start time and attribute set
+type aggregateBaseline struct {
+	Start        time.Time `json:"start"`
+	Key          string    `json:"key"`
+	Value        float64   `json:"value,omitempty"`
+	Count        uint64    `json:"count,omitempty"`
+	Sum          float64   `json:"sum,omitempty"`
+	BucketCounts []uint64  `json:"bucket_counts,omitempty"`
+
+	merged bool
+}
+
+type aggregateAttribute struct {
+	Key   string          `json:"key"`
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Reads the aggregate from disk, starting a new one if there isn't one
+func loadAggregate(filePath string, start time.Time) (*localAggregate, error) {
+	data, err := os.ReadFile(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &localAggregate{Start: start}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	aggregate := &localAggregate{}
+	if err := json.Unmarshal(data, aggregate); err != nil {
+		// A corrupt aggregate can never be exported, so start over
+		return &localAggregate{Start: start}, nil
+	}
+	for _, m := range aggregate.Metrics {
+		for _, p := range m.Points {
+			set := p.set()
+			p.key = set.Encoded(attribute.DefaultEncoder())
+		}
+	}
+	return aggregate, nil
+}
+
+// Writes the aggregate to disk and returns its size
+func (a *localAggregate) save(filePath string) (int, error) {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return 0, err
+	}
+	return len(data), writeFileAtomic(filePath, data)
+}
+
+// Merges the collected metrics into the aggregate. Counters and histograms
+// are added together, with cumulative values only adding what's new since
+// the stream was last merged, while gauges keep the latest value.
+// Attribute sets over the limit are folded into the overflow set, the
+// same as the cardinality limit within a single execution. Returns the
+// metrics that can't be aggregated, which should be exported as is.
+func (a *localAggregate) merge(collected *metricdata.ResourceMetrics, limit int) []metricdata.ScopeMetrics {
+	var unmerged []metricdata.ScopeMetrics
+	for _, scope := range collected.ScopeMetrics {
+		var skipped []metricdata.Metrics
+		for _, m := range scope.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				mergeSum(a.metric(scope.Scope, m, aggregateKindSum, false, data.Temporality, data.IsMonotonic), data, limit)
+			case metricdata.Sum[float64]:
+				mergeSum(a.metric(scope.Scope, m, aggregateKindSum, true, data.Temporality, data.IsMonotonic), data, limit)
+			case metricdata.Gauge[int64]:
+				mergeGauge(a.metric(scope.Scope, m, aggregateKindGauge, false, 0, false), data, limit)
+			case metricdata.Gauge[float64]:
+				mergeGauge(a.metric(scope.Scope, m, aggregateKindGauge, true, 0, false), data, limit)
+			case metricdata.Histogram[int64]:
+				mergeHistogram(a.metric(scope.Scope, m, aggregateKindHistogram, false, data.Temporality, false), data, limit)
+			case metricdata.Histogram[float64]:
+				mergeHistogram(a.metric(scope.Scope, m, aggregateKindHistogram, true, data.Temporality, false), data, limit)
+			default:
+				skipped = append(skipped, m)
+			}
+		}
+		if len(skipped) > 0 {
+			unmerged = append(unmerged, metricdata.ScopeMetrics{Scope: scope.Scope, Metrics: skipped})
+		}
+	}
+	a.pruneBaselines()
+	return unmerged
+}
+
+// Drops the baselines of streams that weren't merged this time. Cumulative
+// streams report every attribute set on every collection, so those are
+// from processes that have since exited and will never be merged again.
+func (a *localAggregate) pruneBaselines() {
+	for _, m := range a.Metrics {
+		for _, p := range m.Points {
+			p.Baselines = slices.DeleteFunc(p.Baselines, func(b *aggregateBaseline) bool {
+				return !b.merged
+			})
+			for _, b := range p.Baselines {
+				b.merged = false
+			}
+		}
+	}
+}
+
+// Finds the aggregated metric, adding it if this is the first time it's seen
+func (a *localAggregate) metric(scope instrumentation.Scope, m metricdata.Metrics, kind string, float bool, temporality metricdata.Temporality, monotonic bool) *aggregateMetric {
+	for _, existing := range a.Metrics {
+		if existing.Scope == scope.Name && existing.Name == m.Name && existing.Kind == kind && existing.Float == float {
+			existing.Description = m.Description
+			existing.Unit = m.Unit
+			return existing
+		}
+	}
+
+	metric := &aggregateMetric{
+		Scope:       scope.Name,
+		Version:     scope.Version,
+		Name:        m.Name,
+		Description: m.Description,
+		Unit:        m.Unit,
+		Kind:        kind,
+		Float:       float,
+		Cumulative:  temporality == metricdata.CumulativeTemporality,
+		Monotonic:   monotonic,
+	}
+	a.Metrics = append(a.Metrics, metric)
+	return metric
+}
+
+// Finds the point for the attribute set, adding it if this is the first
+// time it's seen or folding it into the overflow point if over the limit
+func (m *aggregateMetric) point(set attribute.Set, limit int) *aggregatePoint {
+	key := set.Encoded(attribute.DefaultEncoder())
+	for _, p := range m.Points {
+		if p.key == key {
+			return p
+		}
+	}
+
+	if limit > 0 && len(m.Points) >= limit-1 {
+		set = overflowSet
+		key = set.Encoded(attribute.DefaultEncoder())
+		for _, p := range m.Points {
+			if p.key == key {
+				return p
+			}
+		}
+	}
+
+	p := &aggregatePoint{Attributes: encodeAttributes(set), key: key}
+	m.Points = append(m.Points, p)
+	return p
+}
+
+func mergeSum[N int64 | float64](m *aggregateMetric, data metricdata.Sum[N], limit int) {
+	for _, dp := range data.DataPoints {
+		p := m.point(dp.Attributes, limit)
+		value := float64(dp.Value)
+		if data.Temporality == metricdata.CumulativeTemporality {
+			b := p.baseline(dp.StartTime, dp.Attributes)
+			// A monotonic counter that went down was restarted
+			if data.IsMonotonic && value < b.Value {
+				b.Value = 0
+			}
+			value, b.Value = value-b.Value, value
+		}
+		p.Value += value
+	}
+}
+
+func mergeGauge[N int64 | float64](m *aggregateMetric, data metricdata.Gauge[N], limit int) {
+	for _, dp := range data.DataPoints {
+		m.point(dp.Attributes, limit).Value = float64(dp.Value)
+	}
+}
+
+func mergeHistogram[N int64 | float64](m *aggregateMetric, data metricdata.Histogram[N], limit int) {
+	for _, dp := range data.DataPoints {
+		p := m.point(dp.Attributes, limit)
+
+		// Buckets can only be added together when they line up
+		if !slices.Equal(p.Bounds, dp.Bounds) {
+			*p = aggregatePoint{Attributes: p.Attributes, key: p.key}
+		}
+		if p.BucketCounts == nil {
+			p.Bounds = slices.Clone(dp.Bounds)
+			p.BucketCounts = make([]uint64, len(dp.BucketCounts))
+		}
+
+		counts, count, sum := dp.BucketCounts, dp.Count, float64(dp.Sum)
+		if data.Temporality == metricdata.CumulativeTemporality {
+			b := p.baseline(dp.StartTime, dp.Attributes)
+			// A histogram with fewer values was restarted
+			if count < b.Count || len(b.BucketCounts) != len(counts) {
+				*b = aggregateBaseline{Start: b.Start, Key: b.Key, BucketCounts: make([]uint64, len(counts)), merged: true}
+			}
+			delta := make([]uint64, len(counts))
+			for i := range counts {
+				delta[i] = counts[i] - b.BucketCounts[i]
+			}
+			b.BucketCounts = slices.Clone(counts)
+			counts = delta
+			count, b.Count = count-b.Count, count
+			sum, b.Sum = sum-b.Sum, sum
+		}
+
+		for i, c := range counts {
+			p.BucketCounts[i] += c
+		}
+		p.Count += count
+		p.Sum += sum
+		if v, ok := dp.Min.Value(); ok && (p.Min == nil || float64(v) < *p.Min) {
+			p.Min = ptr(float64(v))
+		}
+		if v, ok := dp.Max.Value(); ok && (p.Max == nil || float64(v) > *p.Max) {
+			p.Max = ptr(float64(v))
+		}
+	}
+}
+
+// Finds the last value merged from the cumulative stream, adding it if
+// this is the first time it's seen
+func (p *aggregatePoint) baseline(start time.Time, set attribute.Set) *aggregateBaseline {
+	key := set.Encoded(attribute.DefaultEncoder())
+	for _, b := range p.Baselines {
+		if b.Start.Equal(start) && b.Key == key {
+			b.merged = true
+			return b
+		}
+	}
+
+	b := &aggregateBaseline{Start: start, Key: key, merged: true}
+	p.Baselines = append(p.Baselines, b)
+	return b
+}
+
+// Determines if the aggregate should be rolled up and exported
+func (a *localAggregate) isDue(current time.Time, window time.Duration, size int, maxSize int) bool {
+	return current.Sub(a.Start) >= window || (maxSize > 0 && size > maxSize)
+}
+
+// Builds the rollup of the aggregate to export, covering the time from
+// when the aggregate was started until now
+func (a *localAggregate) rollup(current time.Time) []metricdata.ScopeMetrics {
+	var scopes []metricdata.ScopeMetrics
+	for _, m := range a.Metrics {
+		i := slices.IndexFunc(scopes, func(s metricdata.ScopeMetrics) bool {
+			return s.Scope.Name == m.Scope
+		})
+		if i < 0 {
+			scopes = append(scopes, metricdata.ScopeMetrics{
+				Scope: instrumentation.Scope{Name: m.Scope, Version: m.Version},
+			})
+			i = len(scopes) - 1
+		}
+
+		data := m.data(a.Start, current)
+		scopes[i].Metrics = append(scopes[i].Metrics, metricdata.Metrics{
+			Name:        m.Name,
+			Description: m.Description,
+			Unit:        m.Unit,
+			Data:        data,
+		})
+	}
+	return scopes
+}
+
+func (m *aggregateMetric) temporality() metricdata.Temporality {
+	if m.Cumulative {
+		return metricdata.CumulativeTemporality
+	}
+	return metricdata.DeltaTemporality
+}
+
+func (m *aggregateMetric) data(start, current time.Time) metricdata.Aggregation {
+	if m.Float {
+		return aggregateData[float64](m, start, current)
+	}
+	return aggregateData[int64](m, start, current)
+}
+
+func aggregateData[N int64 | float64](m *aggregateMetric, start, current time.Time) metricdata.Aggregation {
+	switch m.Kind {
+	case aggregateKindSum:
+		return metricdata.Sum[N]{
+			DataPoints:  dataPoints[N](m.Points, start, current),
+			Temporality: m.temporality(),
+			IsMonotonic: m.Monotonic,
+		}
+	case aggregateKindGauge:
+		return metricdata.Gauge[N]{
+			DataPoints: dataPoints[N](m.Points, start, current),
+		}
+	default:
+		points := make([]metricdata.HistogramDataPoint[N], 0, len(m.Points))
+		for _, p := range m.Points {
+			dp := metricdata.HistogramDataPoint[N]{
+				Attributes:   p.set(),
+				StartTime:    start,
+				Time:         current,
+				Count:        p.Count,
+				Bounds:       p.Bounds,
+				BucketCounts: p.BucketCounts,
+				Sum:          N(p.Sum),
+			}
+			if p.Min != nil {
+				dp.Min = metricdata.NewExtrema(N(*p.Min))
+			}
+			if p.Max != nil {
+				dp.Max = metricdata.NewExtrema(N(*p.Max))
+			}
+			points = append(points, dp)
+		}
+		return metricdata.Histogram[N]{
+			DataPoints:  points,
+			Temporality: m.temporality(),
+		}
+	}
+}
+
+func dataPoints[N int64 | float64](points []*aggregatePoint, start, current time.Time) []metricdata.DataPoint[N] {
+	dataPoints := make([]metricdata.DataPoint[N], 0, len(points))
+	for _, p := range points {
+		dataPoints = append(dataPoints, metricdata.DataPoint[N]{
+			Attributes: p.set(),
+			StartTime:  start,
+			Time:       current,
+			Value:      N(p.Value),
+		})
+	}
+	return dataPoints
+}
+
+func (p *aggregatePoint) set() attribute.Set {
+	return decodeAttributes(p.Attributes)
+}
+
+func encodeAttributes(set attribute.Set) []aggregateAttribute {
+	attributes := make([]aggregateAttribute, 0, set.Len())
+	for _, kv := range set.ToSlice() {
+		value, err := json.Marshal(kv.Value.AsInterface())
+		if err != nil {
+			continue
+		}
+		attributes = append(attributes, aggregateAttribute{
+			Key:   string(kv.Key),
+			Type:  kv.Value.Type().String(),
+			Value: value,
+		})
+	}
+	return attributes
+}
+
+func decodeAttributes(attributes []aggregateAttribute) attribute.Set {
+	kvs := make([]attribute.KeyValue, 0, len(attributes))
+	for _, a := range attributes {
+		kv, err := decodeAttribute(a)
+		if err != nil {
+			continue
+		}
+		kvs = append(kvs, kv)
+	}
+	return attribute.NewSet(kvs...)
+}
+
+func decodeAttribute(a aggregateAttribute) (attribute.KeyValue, error) {
+	key := attribute.Key(a.Key)
+	switch a.Type {
+	case attribute.BOOL.String():
+		var v bool
+		err := json.Unmarshal(a.Value, &v)
+		return key.Bool(v), err
+	case attribute.INT64.String():
+		var v int64
+		err := json.Unmarshal(a.Value, &v)
+		return key.Int64(v), err
+	case attribute.FLOAT64.String():
+		var v float64
+		err := json.Unmarshal(a.Value, &v)
+		return key.Float64(v), err
+	case attribute.STRING.String():
+		var v string
+		err := json.Unmarshal(a.Value, &v)
+		return key.String(v), err
+	case attribute.BOOLSLICE.String():
+		var v []bool
+		err := json.Unmarshal(a.Value, &v)
+		return key.BoolSlice(v), err
+	case attribute.INT64SLICE.String():
+		var v []int64
+		err := json.Unmarshal(a.Value, &v)
+		return key.Int64Slice(v), err
+	case attribute.FLOAT64SLICE.String():
+		var v []float64
+		err := json.Unmarshal(a.Value, &v)
+		return key.Float64Slice(v), err
+	case attribute.STRINGSLICE.String():
+		var v []string
+		err := json.Unmarshal(a.Value, &v)
+		return key.StringSlice(v), err
+	default:
+		return attribute.KeyValue{}, fmt.Errorf("unknown attribute type %s", a.Type)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func getDefaultAggregateFilePath(cmdName string) string {
+	return getDefaultConsentFilePath(cmdName, defaultAggregateFilenamePostfix)
+}
@@ -0,0 +1,309 @@
+package internal
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func testCollected(command string, value int64) *metricdata.ResourceMetrics {
+	return &metricdata.ResourceMetrics{
+		ScopeMetrics: []metricdata.ScopeMetrics{{
+			Scope: instrumentation.Scope{Name: "cobra-otel-metrics"},
+			Metrics: []metricdata.Metrics{
+				{
+					Name: "invocations",
+					Data: metricdata.Sum[int64]{
+						Temporality: metricdata.DeltaTemporality,
+						IsMonotonic: true,
+						DataPoints: []metricdata.DataPoint[int64]{{
+							Attributes: attribute.NewSet(
+								attribute.String("command", command),
+								attribute.StringSlice("shorthand_flags", []string{"v"}),
+								attribute.Bool("tty", true),
+							),
+							Value: value,
+						}},
+					},
+				},
+				{
+					Name: "duration",
+					Data: metricdata.Histogram[float64]{
+						Temporality: metricdata.DeltaTemporality,
+						DataPoints: []metricdata.HistogramDataPoint[float64]{{
+							Attributes:   attribute.NewSet(attribute.String("command", command)),
+							Count:        1,
+							Bounds:       []float64{1, 10},
+							BucketCounts: []uint64{0, 1, 0},
+							Sum:          float64(value),
+							Min:          metricdata.NewExtrema(float64(value)),
+							Max:          metricdata.NewExtrema(float64(value)),
+						}},
+					},
+				},
+				{
+					Name: "exponential",
+					Data: metricdata.ExponentialHistogram[float64]{},
+				},
+			},
+		}},
+	}
+}
+
+func TestLocalAggregateMergeAndRollup(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), ".my-cli-metrics-aggregate")
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, value := range []int64{2, 3} {
+		aggregate, err := loadAggregate(filePath, start)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		unmerged := aggregate.merge(testCollected("root", value), 0)
+		if len(unmerged) != 1 || len(unmerged[0].Metrics) != 1 || unmerged[0].Metrics[0].Name != "exponential" {
+			t.Errorf("Expected only the exponential histogram to be unmerged, got %+v", unmerged)
+		}
+
+		if _, err := aggregate.save(filePath); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	aggregate, err := loadAggregate(filePath, start.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !aggregate.Start.Equal(start) {
+		t.Errorf("Expected aggregate to keep its start %v, got %v", start, aggregate.Start)
+	}
+
+	current := start.Add(24 * time.Hour)
+	scopes := aggregate.rollup(current)
+	if len(scopes) != 1 || len(scopes[0].Metrics) != 2 {
+		t.Fatalf("Expected 2 rolled up metrics, got %+v", scopes)
+	}
+
+	sum, ok := scopes[0].Metrics[0].Data.(metricdata.Sum[int64])
+	if !ok {
+		t.Fatalf("Expected Sum[int64], got %T", scopes[0].Metrics[0].Data)
+	}
+	if len(sum.DataPoints) != 1 || sum.DataPoints[0].Value != 5 {
+		t.Errorf("Expected a single point with value 5, got %+v", sum.DataPoints)
+	}
+	point := sum.DataPoints[0]
+	if !point.StartTime.Equal(start) || !point.Time.Equal(current) {
+		t.Errorf("Expected point to cover %v to %v, got %v to %v", start, current, point.StartTime, point.Time)
+	}
+	expected := attribute.NewSet(
+		attribute.String("command", "root"),
+		attribute.StringSlice("shorthand_flags", []string{"v"}),
+		attribute.Bool("tty", true),
+	)
+	if !point.Attributes.Equals(&expected) {
+		t.Errorf("Expected attributes to survive the round trip, got %v", point.Attributes.Encoded(attribute.DefaultEncoder()))
+	}
+
+	histogram, ok := scopes[0].Metrics[1].Data.(metricdata.Histogram[float64])
+	if !ok {
+		t.Fatalf("Expected Histogram[float64], got %T", scopes[0].Metrics[1].Data)
+	}
+	hp := histogram.DataPoints[0]
+	if hp.Count != 2 || hp.Sum != 5 || hp.BucketCounts[1] != 2 {
+		t.Errorf("Expected histogram points to be added together, got %+v", hp)
+	}
+	if min, _ := hp.Min.Value(); min != 2 {
+		t.Errorf("Expected min 2, got %v", min)
+	}
+	if max, _ := hp.Max.Value(); max != 3 {
+		t.Errorf("Expected max 3, got %v", max)
+	}
+}
+
+// Each process reports its own cumulative stream, starting from zero
+func testCumulative(processStart time.Time, value int64) *metricdata.ResourceMetrics {
+	collected := testCollected("root", value)
+	metrics := collected.ScopeMetrics[0].Metrics
+
+	sum := metrics[0].Data.(metricdata.Sum[int64])
+	sum.Temporality = metricdata.CumulativeTemporality
+	sum.DataPoints[0].StartTime = processStart
+	metrics[0].Data = sum
+
+	histogram := metrics[1].Data.(metricdata.Histogram[float64])
+	histogram.Temporality = metricdata.CumulativeTemporality
+	histogram.DataPoints[0].StartTime = processStart
+	histogram.DataPoints[0].Count = uint64(value)
+	histogram.DataPoints[0].BucketCounts = []uint64{0, uint64(value), 0}
+	metrics[1].Data = histogram
+	return collected
+}
+
+func TestLocalAggregateCumulative(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), ".my-cli-metrics-aggregate")
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		processStart time.Time
+		value        int64
+		want         float64
+	}{
+		{"first process", start, 1, 1},
+		{"second process", start.Add(time.Minute), 1, 2},
+		{"second process again", start.Add(time.Minute), 3, 4},
+		{"restarted counter", start.Add(time.Minute), 1, 5},
+		{"third process", start.Add(2 * time.Minute), 1, 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aggregate, err := loadAggregate(filePath, start)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			aggregate.merge(testCumulative(tt.processStart, tt.value), 0)
+			if _, err := aggregate.save(filePath); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if got := aggregate.Metrics[0].Points[0].Value; got != tt.want {
+				t.Errorf("sum = %v, want %v", got, tt.want)
+			}
+			if got := aggregate.Metrics[1].Points[0].Count; got != uint64(tt.want) {
+				t.Errorf("histogram count = %v, want %v", got, tt.want)
+			}
+			if got := aggregate.Metrics[1].Points[0].BucketCounts[1]; got != uint64(tt.want) {
+				t.Errorf("histogram bucket = %v, want %v", got, tt.want)
+			}
+
+			// Only the baseline of the process just merged is kept
+			for _, m := range aggregate.Metrics {
+				baselines := m.Points[0].Baselines
+				if len(baselines) != 1 || !baselines[0].Start.Equal(tt.processStart) {
+					t.Errorf("%s baselines = %+v, want only the one started at %v", m.Name, baselines, tt.processStart)
+				}
+			}
+		})
+	}
+}
+
+func TestLockFile(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), ".my-cli-metrics-aggregate")
+
+	unlock, err := lockFile(filePath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		unlock, err := lockFile(filePath)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		} else {
+			unlock()
+		}
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("Expected the second lock to wait for the first to be released")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the second lock once the first was released")
+	}
+}
+
+func TestLocalAggregateCardinalityLimit(t *testing.T) {
+	aggregate := &localAggregate{}
+	for _, command := range []string{"a", "b", "c", "d"} {
+		aggregate.merge(testCollected(command, 1), 3)
+	}
+
+	points := aggregate.Metrics[0].Points
+	if len(points) != 3 {
+		t.Fatalf("Expected 3 points, got %d", len(points))
+	}
+
+	overflow := points[2].set()
+	if !overflow.Equals(&overflowSet) || points[2].Value != 2 {
+		t.Errorf("Expected the last 2 sets to be folded into overflow, got %v = %v", overflow.Encoded(attribute.DefaultEncoder()), points[2].Value)
+	}
+}
+
+func TestLocalAggregateIsDue(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	aggregate := &localAggregate{Start: start}
+
+	tests := []struct {
+		name     string
+		current  time.Time
+		size     int
+		expected bool
+	}{
+		{name: "inside window", current: start.Add(time.Hour), size: 10, expected: false},
+		{name: "window elapsed", current: start.Add(24 * time.Hour), size: 10, expected: true},
+		{name: "over max size", current: start.Add(time.Hour), size: 101, expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := aggregate.isDue(tt.current, 24*time.Hour, tt.size, 100); got != tt.expected {
+				t.Errorf("isDue() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAggregateLocally(t *testing.T) {
+	defer func(original func() time.Time) { now = original }(now)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	ctx := context.Background()
+	provider, err := NewMetricsProvider(ctx, &Config{
+		ServiceName:       "test-service",
+		AggregationWindow: 24 * time.Hour,
+		AggregateFile:     filepath.Join(t.TempDir(), ".test-service-metrics-aggregate"),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer provider.Shutdown(ctx)
+
+	now = func() time.Time { return start }
+	export, rolledUp, err := provider.AggregateLocally(testCollected("root", 1))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if rolledUp || len(export.ScopeMetrics) != 1 || export.ScopeMetrics[0].Metrics[0].Name != "exponential" {
+		t.Errorf("Expected only unmerged metrics before the window elapses, got %+v", export.ScopeMetrics)
+	}
+
+	now = func() time.Time { return start.Add(25 * time.Hour) }
+	export, rolledUp, err = provider.AggregateLocally(testCollected("root", 1))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !rolledUp || len(export.ScopeMetrics) != 2 {
+		t.Errorf("Expected the rollup once the window elapses, got %+v", export.ScopeMetrics)
+	}
+
+	if err := provider.ClearLocalAggregate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := provider.ClearLocalAggregate(); err != nil {
+		t.Errorf("Expected clearing a missing aggregate to succeed, got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package internal
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Cobra command annotations that control telemetry per command. All of
+// them are inherited by subcommands unless a subcommand sets its own.
+const (
+	// Set to "true" to never record telemetry for the command
+	AnnotationDisabled = "otel.metrics/disabled"
+
+	// Replaces the command's name in the reported command path
+	AnnotationName = "otel.metrics/name"
+
+	// Prefix for extra attributes added to the invocation metric, e.g.
+	// "otel.metrics/attr.team": "platform"
+	AnnotationAttributePrefix = "otel.metrics/attr."
+
+	// Set to "true" for commands that run for a long time, such as
+	// serve or watch, to export metrics periodically while they run
+	AnnotationLongRunning = "otel.metrics/long-running"
+
+	// Set to "true" to serve the Prometheus /metrics endpoint while the
+	// command runs, when configured with WithPrometheusEndpoint
+	AnnotationPrometheus = "otel.metrics/prometheus"
+
+	// Set to a rate between 0 and 1 to sample the command's invocations
+	// at that rate instead of the configured sampling
+	AnnotationSampleRate = "otel.metrics/sample-rate"
+)
+
+// Looks up an annotation on the command, falling back to the
+// closest parent that sets it
+func lookupAnnotation(cmd *cobra.Command, key string) (string, bool) {
+	for c := cmd; c != nil; c = c.Parent() {
+		if value, ok := c.Annotations[key]; ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// Determines if telemetry has been disabled for the command or any of
+// its parents with the AnnotationDisabled annotation
+func IsCommandTelemetryDisabled(cmd *cobra.Command) bool {
+	return lookupBoolAnnotation(cmd, AnnotationDisabled)
+}
+
+// Determines if the command or any of its parents have been marked
+// as long running with the AnnotationLongRunning annotation
+func IsCommandLongRunning(cmd *cobra.Command) bool {
+	return lookupBoolAnnotation(cmd, AnnotationLongRunning)
+}
+
+// Determines if the command or any of its parents have been marked
+// to serve the Prometheus endpoint with the AnnotationPrometheus annotation
+func IsCommandPrometheusEnabled(cmd *cobra.Command) bool {
+	return lookupBoolAnnotation(cmd, AnnotationPrometheus)
+}
+
+// Looks up an inherited annotation as a bool, anything that
+// isn't a valid bool counts as false
+func lookupBoolAnnotation(cmd *cobra.Command, key string) bool {
+	value, ok := lookupAnnotation(cmd, key)
+	if !ok {
+		return false
+	}
+	enabled, err := strconv.ParseBool(value)
+	return err == nil && enabled
+}
+
+// Returns the name the command is reported as, which is the
+// AnnotationName annotation if set, otherwise the command's name
+func getCmdReportedName(cmd *cobra.Command) string {
+	if name, ok := cmd.Annotations[AnnotationName]; ok && name != "" {
+		return name
+	}
+	return cmd.Name()
+}
+
+// Collects the AnnotationAttributePrefix annotations from the root
+// command down to this command, with subcommands overriding the
+// values set by their parents
+func ParseCmdAnnotationsToAttributes(cmd *cobra.Command) []attribute.KeyValue {
+	values := map[string]string{}
+	keys := []string{}
+	for _, c := range getCmdLineage(cmd) {
+		for annotation, value := range c.Annotations {
+			key, ok := strings.CutPrefix(annotation, AnnotationAttributePrefix)
+			if !ok || key == "" {
+				continue
+			}
+			if _, seen := values[key]; !seen {
+				keys = append(keys, key)
+			}
+			values[key] = value
+		}
+	}
+
+	attributes := make([]attribute.KeyValue, 0, len(keys))
+	for _, key := range keys {
+		attributes = append(attributes, attribute.String(key, values[key]))
+	}
+	return attributes
+}
+
+// Returns the commands from the root command down to this command
+func getCmdLineage(cmd *cobra.Command) []*cobra.Command {
+	lineage := []*cobra.Command{}
+	for c := cmd; c != nil; c = c.Parent() {
+		lineage = append([]*cobra.Command{c}, lineage...)
+	}
+	return lineage
+}
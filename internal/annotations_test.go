@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func newAnnotatedCmdTree() (root, secrets, get *cobra.Command) {
+	root = &cobra.Command{
+		Use: "myapp",
+		Annotations: map[string]string{
+			AnnotationAttributePrefix + "team": "platform",
+		},
+	}
+	secrets = &cobra.Command{
+		Use: "secrets",
+		Annotations: map[string]string{
+			AnnotationName:                      "vault",
+			AnnotationDisabled:                  "true",
+			AnnotationAttributePrefix + "team":  "security",
+			AnnotationAttributePrefix + "scope": "sensitive",
+		},
+	}
+	get = &cobra.Command{Use: "get"}
+	root.AddCommand(secrets)
+	secrets.AddCommand(get)
+	return root, secrets, get
+}
+
+func TestIsCommandTelemetryDisabled(t *testing.T) {
+	root, secrets, get := newAnnotatedCmdTree()
+	list := &cobra.Command{
+		Use:         "list",
+		Annotations: map[string]string{AnnotationDisabled: "false"},
+	}
+	secrets.AddCommand(list)
+
+	tests := []struct {
+		name     string
+		cmd      *cobra.Command
+		expected bool
+	}{
+		{name: "not annotated", cmd: root, expected: false},
+		{name: "annotated", cmd: secrets, expected: true},
+		{name: "inherited", cmd: get, expected: true},
+		{name: "re-enabled", cmd: list, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := IsCommandTelemetryDisabled(tt.cmd)
+			if result != tt.expected {
+				t.Errorf("IsCommandTelemetryDisabled() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseCmdNameAnnotated(t *testing.T) {
+	_, secrets, get := newAnnotatedCmdTree()
+
+	if result := ParseCmdName(secrets); result != "vault" {
+		t.Errorf("ParseCmdName() = %v, want vault", result)
+	}
+
+	if result := ParseCmdName(get); result != "vault-get" {
+		t.Errorf("ParseCmdName() = %v, want vault-get", result)
+	}
+}
+
+func TestParseCmdAnnotationsToAttributes(t *testing.T) {
+	root, _, get := newAnnotatedCmdTree()
+
+	tests := []struct {
+		name     string
+		cmd      *cobra.Command
+		expected map[string]string
+	}{
+		{
+			name:     "root",
+			cmd:      root,
+			expected: map[string]string{"team": "platform"},
+		},
+		{
+			name:     "inherited and overridden",
+			cmd:      get,
+			expected: map[string]string{"team": "security", "scope": "sensitive"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := ParseCmdAnnotationsToAttributes(tt.cmd)
+
+			if len(result) != len(tt.expected) {
+				t.Errorf("ParseCmdAnnotationsToAttributes() returned %d attributes, want %d", len(result), len(tt.expected))
+			}
+
+			for _, attr := range result {
+				if tt.expected[string(attr.Key)] != attr.Value.AsString() {
+					t.Errorf("Unexpected attribute %s=%s", attr.Key, attr.Value.AsString())
+				}
+			}
+		})
+	}
+}
+
+func TestIsCommandLongRunning(t *testing.T) {
+	root := &cobra.Command{Use: "myapp"}
+	agent := &cobra.Command{
+		Use:         "agent",
+		Annotations: map[string]string{AnnotationLongRunning: "true"},
+	}
+	run := &cobra.Command{Use: "run"}
+	root.AddCommand(agent)
+	agent.AddCommand(run)
+
+	if IsCommandLongRunning(root) {
+		t.Error("Expected root not to be long running")
+	}
+
+	if !IsCommandLongRunning(agent) {
+		t.Error("Expected annotated command to be long running")
+	}
+
+	if !IsCommandLongRunning(run) {
+		t.Error("Expected subcommand to inherit long running")
+	}
+}
@@ -0,0 +1,60 @@
+package internal
+
+import (
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const DefaultCardinalityLimit = 2000
+
+// OverflowAttribute marks the series that attribute sets over the
+// cardinality limit are folded into
+const OverflowAttribute = "otel.metric.overflow"
+
+var overflowSet = attribute.NewSet(attribute.Bool(OverflowAttribute, true))
+
+// cardinalityLimiter caps the distinct attribute sets recorded per
+// instrument. Sets over the limit are folded into the overflow set, which
+// counts towards the limit the same as it does in the OTel SDK.
+type cardinalityLimiter struct {
+	limit int
+
+	mu   sync.Mutex
+	seen map[string]map[attribute.Distinct]struct{}
+}
+
+func newCardinalityLimiter(limit int) *cardinalityLimiter {
+	return &cardinalityLimiter{
+		limit: limit,
+		seen:  map[string]map[attribute.Distinct]struct{}{},
+	}
+}
+
+func (l *cardinalityLimiter) process(instrument string, set attribute.Set) attribute.Set {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sets, ok := l.seen[instrument]
+	if !ok {
+		sets = map[attribute.Distinct]struct{}{}
+		l.seen[instrument] = sets
+	}
+
+	if _, ok := sets[set.Equivalent()]; ok {
+		return set
+	}
+	if len(sets) >= l.limit-1 {
+		return overflowSet
+	}
+	sets[set.Equivalent()] = struct{}{}
+	return set
+}
+
+// Forgets every attribute set seen so far
+func (l *cardinalityLimiter) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seen = map[string]map[attribute.Distinct]struct{}{}
+}
@@ -0,0 +1,41 @@
+package internal
+
+import (
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestCardinalityLimiter(t *testing.T) {
+	limiter := newCardinalityLimiter(3)
+
+	a := attribute.NewSet(attribute.String("command", "a"))
+	b := attribute.NewSet(attribute.String("command", "b"))
+	c := attribute.NewSet(attribute.String("command", "c"))
+
+	tests := []struct {
+		name       string
+		instrument string
+		set        attribute.Set
+		expected   attribute.Set
+	}{
+		{name: "first set", instrument: "invocations", set: a, expected: a},
+		{name: "second set", instrument: "invocations", set: b, expected: b},
+		{name: "over the limit", instrument: "invocations", set: c, expected: overflowSet},
+		{name: "seen set", instrument: "invocations", set: a, expected: a},
+		{name: "other instrument", instrument: "errors", set: c, expected: c},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := limiter.process(tt.instrument, tt.set); !got.Equals(&tt.expected) {
+				t.Errorf("process() = %v, want %v", got.Encoded(attribute.DefaultEncoder()), tt.expected.Encoded(attribute.DefaultEncoder()))
+			}
+		})
+	}
+
+	limiter.reset()
+	if got := limiter.process("invocations", c); !got.Equals(&c) {
+		t.Errorf("process() after reset = %v, want %v", got.Encoded(attribute.DefaultEncoder()), c.Encoded(attribute.DefaultEncoder()))
+	}
+}
@@ -1,6 +1,8 @@
 package internal
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"strings"
 
@@ -12,15 +14,173 @@ import (
 
 // Returns the full subcommand path without the root command
 // however if the root command is the only command called we
-// return "root"
+// return "root". Commands with the AnnotationName annotation
+// are reported under that name instead.
 func ParseCmdName(cmd *cobra.Command) string {
-	cmdString := cmd.CommandPath()
-	stringArr := strings.Split(cmdString, " ")
-	if len(stringArr) == 1 {
+	lineage := getCmdLineage(cmd)
+	if len(lineage) == 1 {
 		return "root"
 	}
-	stringArr = stringArr[1:]
-	return strings.Join(stringArr, "-")
+	names := []string{}
+	for _, c := range lineage[1:] {
+		names = append(names, getCmdReportedName(c))
+	}
+	return strings.Join(names, "-")
+}
+
+// Returns the command path as the user typed it, so aliases are
+// reported instead of the canonical names. Only tokens matching a
+// command name or alias are used, any command that can't be found
+// in the args falls back to its canonical name. Commands renamed with
+// the name annotation are reported by that name, marked with "(alias)"
+// when an alias was used, so their real names and aliases never leak.
+func ParseCmdInvokedAs(cmd *cobra.Command, args []string) string {
+	lineage := getCmdLineage(cmd)
+	if len(lineage) == 1 {
+		return "root"
+	}
+
+	names := []string{}
+	next := 0
+	for _, c := range lineage[1:] {
+		typed := c.Name()
+		if c == cmd && c.CalledAs() != "" {
+			typed = c.CalledAs()
+		}
+		for i := next; i < len(args); i++ {
+			if args[i] == "--" {
+				break
+			}
+			if args[i] == c.Name() || c.HasAlias(args[i]) {
+				typed = args[i]
+				next = i + 1
+				break
+			}
+		}
+
+		name := typed
+		if reported := getCmdReportedName(c); reported != c.Name() {
+			name = reported
+			if typed != c.Name() {
+				name += "(alias)"
+			}
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, "-")
+}
+
+// Returns the names of the flags that were passed using their
+// shorthand rather than their long form
+func ParseCmdShorthandFlags(cmd *cobra.Command, args []string) []string {
+	shorthands := map[byte]*pflag.Flag{}
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if len(f.Shorthand) == 1 {
+			shorthands[f.Shorthand[0]] = f
+		}
+	})
+
+	seen := map[string]bool{}
+	names := []string{}
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' || arg[1] == '-' {
+			continue
+		}
+		// Shorthands can be combined, e.g. -abc, until one of them
+		// takes a value which is the rest of the arg
+		for i := 1; i < len(arg); i++ {
+			f, ok := shorthands[arg[i]]
+			if !ok {
+				break
+			}
+			_, excluded := f.Annotations[ExcludeFlagAnnotation]
+			if f.Changed && !excluded && !seen[f.Name] {
+				seen[f.Name] = true
+				names = append(names, f.Name)
+			}
+			if f.NoOptDefVal == "" {
+				break
+			}
+		}
+	}
+	return names
+}
+
+// Kinds of deprecated usage
+const (
+	DeprecatedKindCommand   = "command"
+	DeprecatedKindFlag      = "flag"
+	DeprecatedKindShorthand = "shorthand"
+)
+
+// A deprecated command, flag or flag shorthand used in an invocation
+type DeprecatedUsage struct {
+	Kind    string
+	Command string
+	Flag    string
+}
+
+// Returns every deprecated command in the command path, deprecated
+// flag and deprecated flag shorthand used in this invocation
+func ParseCmdDeprecatedUsage(cmd *cobra.Command, args []string) []DeprecatedUsage {
+	usages := []DeprecatedUsage{}
+	for _, c := range getCmdLineage(cmd) {
+		if c.Deprecated != "" {
+			usages = append(usages, DeprecatedUsage{
+				Kind:    DeprecatedKindCommand,
+				Command: ParseCmdName(c),
+			})
+		}
+	}
+
+	cmdName := ParseCmdName(cmd)
+	shorthandFlags := map[string]bool{}
+	for _, name := range ParseCmdShorthandFlags(cmd, args) {
+		shorthandFlags[name] = true
+	}
+	visitChangedFlags(cmd, func(f *pflag.Flag) {
+		if _, excluded := f.Annotations[ExcludeFlagAnnotation]; excluded {
+			return
+		}
+		if f.Deprecated != "" {
+			usages = append(usages, DeprecatedUsage{
+				Kind:    DeprecatedKindFlag,
+				Command: cmdName,
+				Flag:    f.Name,
+			})
+		}
+		if f.ShorthandDeprecated != "" && shorthandFlags[f.Name] {
+			usages = append(usages, DeprecatedUsage{
+				Kind:    DeprecatedKindShorthand,
+				Command: cmdName,
+				Flag:    f.Name,
+			})
+		}
+	})
+	return usages
+}
+
+// Determines if the command is cobra's hidden command that shells
+// call for completions on every TAB
+func IsCompletionRequest(cmd *cobra.Command) bool {
+	return cmd.Name() == cobra.ShellCompRequestCmd || cmd.Name() == cobra.ShellCompNoDescRequestCmd
+}
+
+// Returns the command being completed by a completion request, from
+// the args passed to the completion command. The last arg is the
+// partial word being completed so it is ignored.
+func ParseCompletionTarget(cmd *cobra.Command, args []string) *cobra.Command {
+	if len(args) > 0 {
+		args = args[:len(args)-1]
+	}
+	target, _, err := cmd.Root().Find(args)
+	if err != nil || target == nil {
+		return cmd.Root()
+	}
+	return target
 }
 
 // Returns the root command name
@@ -30,15 +190,25 @@ func GetRootCmdName(cmd *cobra.Command) string {
 	return stringArr[0]
 }
 
+// Flags annotated with this are never turned into attributes
+const ExcludeFlagAnnotation = "otel.metrics/exclude"
+
+const DefaultNoTelemetryFlag = "no-telemetry"
+
+var ErrNoTelemetryFlagExists = errors.New("a flag with the no-telemetry flag name already exists")
+
 // Loops through all provided flags and converts ONLY the
 // name of the flag (NOT THE VALUE) to an attribute for
 // additional metric collection
 func ParseCmdFlagsToAttributes(cmd *cobra.Command) []attribute.KeyValue {
 	flags := []attribute.KeyValue{}
 	parseFlag := func(f *pflag.Flag) {
+		if _, excluded := f.Annotations[ExcludeFlagAnnotation]; excluded {
+			return
+		}
 		flags = append(flags, attribute.Int(f.Name, 1))
 	}
-	cmd.Flags().Visit(parseFlag)
+	visitChangedFlags(cmd, parseFlag)
 
 	return flags
 }
@@ -51,3 +221,43 @@ func IsTTY() bool {
 	}
 	return isTTY
 }
+
+// Adds the configured no-telemetry flag to the root command's persistent
+// flags, excluded from the flag attributes
+func AddNoTelemetryFlag(cmd *cobra.Command, config *Config) error {
+	if config.NoTelemetryFlag == "" {
+		return nil
+	}
+
+	flags := cmd.PersistentFlags()
+	if flags.Lookup(config.NoTelemetryFlag) != nil {
+		return fmt.Errorf("%w: %s", ErrNoTelemetryFlagExists, config.NoTelemetryFlag)
+	}
+
+	flags.Bool(config.NoTelemetryFlag, false, "Disable telemetry for this invocation")
+	flags.SetAnnotation(config.NoTelemetryFlag, ExcludeFlagAnnotation, []string{"true"})
+	if config.NoTelemetryFlagHidden {
+		flags.MarkHidden(config.NoTelemetryFlag)
+	}
+	return nil
+}
+
+// Determines if telemetry was disabled for this invocation with the
+// no-telemetry flag
+func IsTelemetryDisabledByFlag(cmd *cobra.Command, config *Config) bool {
+	if config.NoTelemetryFlag == "" {
+		return false
+	}
+	disabled, err := cmd.Flags().GetBool(config.NoTelemetryFlag)
+	return err == nil && disabled
+}
+
+// Visits the flags set in this execution. FlagSet.Visit can't be used as
+// pflag never forgets a flag was set, even once a FlagSnapshot restores it.
+func visitChangedFlags(cmd *cobra.Command, fn func(*pflag.Flag)) {
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if f.Changed {
+			fn(f)
+		}
+	})
+}
@@ -1,6 +1,8 @@
 package internal
 
 import (
+	"errors"
+	"io"
 	"testing"
 
 	"github.com/spf13/cobra"
@@ -185,4 +187,377 @@ func TestParseCmdFlagsToAttributesValues(t *testing.T) {
 	if attr.Value.AsInt64() != 1 {
 		t.Errorf("Expected value 1, got %v", attr.Value.AsInt64())
 	}
-}
\ No newline at end of file
+}
+
+func TestParseCmdFlagsToAttributesExcluded(t *testing.T) {
+	cmd := &cobra.Command{Use: "test", Run: func(cmd *cobra.Command, args []string) {}}
+	cmd.Flags().String("format", "", "output format")
+	cmd.Flags().Bool("secret", false, "never reported")
+	cmd.Flags().SetAnnotation("secret", ExcludeFlagAnnotation, []string{"true"})
+	cmd.SetArgs([]string{"--format", "json", "--secret"})
+	cmd.Execute()
+
+	result := ParseCmdFlagsToAttributes(cmd)
+
+	if len(result) != 1 {
+		t.Fatalf("Expected 1 attribute, got %d", len(result))
+	}
+
+	if string(result[0].Key) != "format" {
+		t.Errorf("Expected key 'format', got %s", string(result[0].Key))
+	}
+}
+
+func TestAddNoTelemetryFlag(t *testing.T) {
+	tests := []struct {
+		name           string
+		config         *Config
+		args           []string
+		expectFlag     bool
+		expectHidden   bool
+		expectDisabled bool
+	}{
+		{
+			name:       "no flag configured",
+			config:     &Config{},
+			args:       []string{"sub"},
+			expectFlag: false,
+		},
+		{
+			name:           "flag not passed",
+			config:         &Config{NoTelemetryFlag: DefaultNoTelemetryFlag},
+			args:           []string{"sub"},
+			expectFlag:     true,
+			expectDisabled: false,
+		},
+		{
+			name:           "flag passed to subcommand",
+			config:         &Config{NoTelemetryFlag: DefaultNoTelemetryFlag},
+			args:           []string{"sub", "--no-telemetry"},
+			expectFlag:     true,
+			expectDisabled: true,
+		},
+		{
+			name:           "hidden flag with custom name",
+			config:         &Config{NoTelemetryFlag: "offline", NoTelemetryFlagHidden: true},
+			args:           []string{"sub", "--offline"},
+			expectFlag:     true,
+			expectHidden:   true,
+			expectDisabled: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var executed *cobra.Command
+			root := &cobra.Command{Use: "myapp"}
+			sub := &cobra.Command{Use: "sub", Run: func(cmd *cobra.Command, args []string) { executed = cmd }}
+			root.AddCommand(sub)
+
+			if err := AddNoTelemetryFlag(root, tt.config); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			flag := root.PersistentFlags().Lookup(tt.config.NoTelemetryFlag)
+			if !tt.expectFlag {
+				if tt.config.NoTelemetryFlag != "" || root.PersistentFlags().HasFlags() {
+					t.Error("Expected no flag to be added")
+				}
+				return
+			}
+			if flag == nil {
+				t.Fatal("Expected flag to be added")
+			}
+			if flag.Hidden != tt.expectHidden {
+				t.Errorf("Expected hidden to be %v, got %v", tt.expectHidden, flag.Hidden)
+			}
+
+			root.SetArgs(tt.args)
+			if err := root.Execute(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if IsTelemetryDisabledByFlag(executed, tt.config) != tt.expectDisabled {
+				t.Errorf("Expected telemetry disabled to be %v", tt.expectDisabled)
+			}
+
+			if len(ParseCmdFlagsToAttributes(executed)) != 0 {
+				t.Error("Expected no-telemetry flag to be excluded from attributes")
+			}
+		})
+	}
+}
+
+func TestAddNoTelemetryFlagExists(t *testing.T) {
+	root := &cobra.Command{Use: "myapp"}
+	root.PersistentFlags().Bool(DefaultNoTelemetryFlag, false, "already here")
+
+	err := AddNoTelemetryFlag(root, &Config{NoTelemetryFlag: DefaultNoTelemetryFlag})
+	if !errors.Is(err, ErrNoTelemetryFlagExists) {
+		t.Errorf("Expected ErrNoTelemetryFlagExists, got %v", err)
+	}
+}
+
+func TestParseCmdInvokedAs(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{
+			name:     "root command only",
+			args:     []string{},
+			expected: "root",
+		},
+		{
+			name:     "canonical names",
+			args:     []string{"config", "delete", "key"},
+			expected: "config-delete",
+		},
+		{
+			name:     "alias",
+			args:     []string{"config", "rm", "key"},
+			expected: "config-rm",
+		},
+		{
+			name:     "parent alias with flags",
+			args:     []string{"--verbose", "cfg", "delete", "--force"},
+			expected: "cfg-delete",
+		},
+		{
+			name:     "renamed command",
+			args:     []string{"config", "internal-op"},
+			expected: "config-reported-op",
+		},
+		{
+			name:     "renamed command by alias",
+			args:     []string{"cfg", "iop"},
+			expected: "cfg-reported-op(alias)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var executed *cobra.Command
+			run := func(cmd *cobra.Command, args []string) { executed = cmd }
+			root := &cobra.Command{Use: "myapp", Run: run}
+			root.PersistentFlags().Bool("verbose", false, "verbose output")
+			config := &cobra.Command{Use: "config", Aliases: []string{"cfg"}, Run: run}
+			del := &cobra.Command{Use: "delete", Aliases: []string{"rm"}, Run: run}
+			del.Flags().Bool("force", false, "force delete")
+			renamed := &cobra.Command{
+				Use:         "internal-op",
+				Aliases:     []string{"iop"},
+				Annotations: map[string]string{AnnotationName: "reported-op"},
+				Run:         run,
+			}
+			root.AddCommand(config)
+			config.AddCommand(del, renamed)
+
+			root.SetArgs(tt.args)
+			if err := root.Execute(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			result := ParseCmdInvokedAs(executed, tt.args)
+			if result != tt.expected {
+				t.Errorf("ParseCmdInvokedAs() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseCmdShorthandFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected []string
+	}{
+		{
+			name:     "long form",
+			args:     []string{"--test", "--string", "value"},
+			expected: []string{},
+		},
+		{
+			name:     "shorthand",
+			args:     []string{"-t", "-s", "value"},
+			expected: []string{"test", "string"},
+		},
+		{
+			name:     "combined shorthand with value",
+			args:     []string{"-tsvalue"},
+			expected: []string{"test", "string"},
+		},
+		{
+			name:     "mixed",
+			args:     []string{"--test", "-s=value"},
+			expected: []string{"string"},
+		},
+		{
+			name:     "after terminator",
+			args:     []string{"--string", "value", "--", "-t"},
+			expected: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := &cobra.Command{Use: "test", Run: func(cmd *cobra.Command, args []string) {}}
+			cmd.Flags().BoolP("test", "t", false, "test flag")
+			cmd.Flags().StringP("string", "s", "", "string flag")
+			cmd.SetArgs(tt.args)
+			if err := cmd.Execute(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			result := ParseCmdShorthandFlags(cmd, tt.args)
+			if len(result) != len(tt.expected) {
+				t.Fatalf("ParseCmdShorthandFlags() = %v, want %v", result, tt.expected)
+			}
+			for i := range result {
+				if result[i] != tt.expected[i] {
+					t.Errorf("ParseCmdShorthandFlags() = %v, want %v", result, tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestParseCmdDeprecatedUsage(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected []DeprecatedUsage
+	}{
+		{
+			name:     "nothing deprecated used",
+			args:     []string{"new"},
+			expected: []DeprecatedUsage{},
+		},
+		{
+			name: "deprecated command",
+			args: []string{"old"},
+			expected: []DeprecatedUsage{
+				{Kind: DeprecatedKindCommand, Command: "old"},
+			},
+		},
+		{
+			name: "deprecated flag",
+			args: []string{"new", "--legacy"},
+			expected: []DeprecatedUsage{
+				{Kind: DeprecatedKindFlag, Command: "new", Flag: "legacy"},
+			},
+		},
+		{
+			name:     "deprecated shorthand used in long form",
+			args:     []string{"new", "--output", "json"},
+			expected: []DeprecatedUsage{},
+		},
+		{
+			name: "deprecated shorthand",
+			args: []string{"new", "-o", "json"},
+			expected: []DeprecatedUsage{
+				{Kind: DeprecatedKindShorthand, Command: "new", Flag: "output"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var executed *cobra.Command
+			run := func(cmd *cobra.Command, args []string) { executed = cmd }
+			root := &cobra.Command{Use: "myapp"}
+			root.SetOut(io.Discard)
+			root.SetErr(io.Discard)
+			oldCmd := &cobra.Command{Use: "old", Deprecated: "use new instead", Run: run}
+			newCmd := &cobra.Command{Use: "new", Run: run}
+			newCmd.Flags().Bool("legacy", false, "legacy behavior")
+			newCmd.Flags().MarkDeprecated("legacy", "it does nothing")
+			newCmd.Flags().StringP("output", "o", "", "output format")
+			newCmd.Flags().MarkShorthandDeprecated("output", "use --output")
+			root.AddCommand(oldCmd, newCmd)
+
+			root.SetArgs(tt.args)
+			if err := root.Execute(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			result := ParseCmdDeprecatedUsage(executed, tt.args)
+			if len(result) != len(tt.expected) {
+				t.Fatalf("ParseCmdDeprecatedUsage() = %v, want %v", result, tt.expected)
+			}
+			for i := range result {
+				if result[i] != tt.expected[i] {
+					t.Errorf("ParseCmdDeprecatedUsage() = %v, want %v", result, tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestParseCompletionTarget(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{
+			name:     "completing a subcommand name",
+			args:     []string{cobra.ShellCompRequestCmd, "su"},
+			expected: "root",
+		},
+		{
+			name:     "completing a subcommand flag",
+			args:     []string{cobra.ShellCompNoDescRequestCmd, "sub", "--"},
+			expected: "sub",
+		},
+		{
+			name:     "completing a nested subcommand",
+			args:     []string{cobra.ShellCompRequestCmd, "sub", "child", ""},
+			expected: "sub-child",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var completion *cobra.Command
+			var completionArgs []string
+			root := &cobra.Command{
+				Use: "myapp",
+				PersistentPreRun: func(cmd *cobra.Command, args []string) {
+					completion = cmd
+					completionArgs = args
+				},
+			}
+			root.SetOut(io.Discard)
+			sub := &cobra.Command{Use: "sub", Run: func(cmd *cobra.Command, args []string) {}}
+			child := &cobra.Command{Use: "child", Run: func(cmd *cobra.Command, args []string) {}}
+			root.AddCommand(sub)
+			sub.AddCommand(child)
+
+			root.SetArgs(tt.args)
+			if err := root.Execute(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if !IsCompletionRequest(completion) {
+				t.Fatalf("Expected %s to be a completion request", completion.Name())
+			}
+
+			result := ParseCmdName(ParseCompletionTarget(completion, completionArgs))
+			if result != tt.expected {
+				t.Errorf("ParseCompletionTarget() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIsCompletionRequest(t *testing.T) {
+	if IsCompletionRequest(&cobra.Command{Use: "completion"}) {
+		t.Error("Expected completion script command not to be a completion request")
+	}
+
+	if !IsCompletionRequest(&cobra.Command{Use: cobra.ShellCompRequestCmd}) {
+		t.Error("Expected __complete to be a completion request")
+	}
+}
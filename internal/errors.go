@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Categories for errors that stop a command before it runs. Only the
+// category is ever reported, never the error text, as it can contain
+// the arguments the user passed.
+const (
+	ErrorCategoryFlagParse      = "flag_parse"
+	ErrorCategoryArgsValidation = "args_validation"
+	ErrorCategoryUnknownCommand = "unknown_command"
+	ErrorCategoryOther          = "other"
+)
+
+// Categorizes an error that wasn't already categorized by one of the
+// wrapped cobra hooks. Cobra doesn't give us a typed error for unknown
+// commands so we have to match on the message.
+func CategorizeExecutionError(err error) string {
+	if strings.HasPrefix(err.Error(), "unknown command") {
+		return ErrorCategoryUnknownCommand
+	}
+	return ErrorCategoryOther
+}
+
+// Wraps the command's flag error func and args validator so errors
+// from them are categorized with onError before being returned.
+//
+// Commands without an args validator are left alone as cobra only
+// checks for unknown subcommands when no validator is set.
+func WrapCmdErrorHooks(cmd *cobra.Command, onError func(category string)) {
+	originalFlagErrorFunc := cmd.FlagErrorFunc()
+	cmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		onError(ErrorCategoryFlagParse)
+		return originalFlagErrorFunc(cmd, err)
+	})
+
+	originalArgs := cmd.Args
+	if originalArgs == nil {
+		return
+	}
+	cmd.Args = func(cmd *cobra.Command, args []string) error {
+		err := originalArgs(cmd, args)
+		if err != nil {
+			onError(ErrorCategoryArgsValidation)
+		}
+		return err
+	}
+}
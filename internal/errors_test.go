@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestCategorizeExecutionError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{
+			name:     "unknown command",
+			err:      errors.New(`unknown command "nope" for "myapp"`),
+			expected: ErrorCategoryUnknownCommand,
+		},
+		{
+			name:     "anything else",
+			err:      errors.New("something went wrong"),
+			expected: ErrorCategoryOther,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := CategorizeExecutionError(tt.err)
+			if result != tt.expected {
+				t.Errorf("CategorizeExecutionError() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestWrapCmdErrorHooks(t *testing.T) {
+	tests := []struct {
+		name             string
+		args             []string
+		expectError      bool
+		expectedCategory string
+	}{
+		{
+			name:             "bad flag",
+			args:             []string{"sub", "--bogus"},
+			expectError:      true,
+			expectedCategory: ErrorCategoryFlagParse,
+		},
+		{
+			name:             "wrong arg count",
+			args:             []string{"sub", "extra"},
+			expectError:      true,
+			expectedCategory: ErrorCategoryArgsValidation,
+		},
+		{
+			name:        "unknown command is left to cobra",
+			args:        []string{"nope"},
+			expectError: true,
+		},
+		{
+			name: "no error",
+			args: []string{"sub"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := &cobra.Command{Use: "myapp", SilenceErrors: true, SilenceUsage: true}
+			root.SetOut(io.Discard)
+			sub := &cobra.Command{Use: "sub", Args: cobra.NoArgs, Run: func(cmd *cobra.Command, args []string) {}}
+			root.AddCommand(sub)
+
+			var category string
+			onError := func(c string) {
+				category = c
+			}
+			WrapCmdErrorHooks(root, onError)
+			WrapCmdErrorHooks(sub, onError)
+
+			if root.Args != nil {
+				t.Error("Expected root args validator to be left unset")
+			}
+
+			root.SetArgs(tt.args)
+			err := root.Execute()
+			if (err != nil) != tt.expectError {
+				t.Fatalf("Unexpected error result: %v", err)
+			}
+
+			if category != tt.expectedCategory {
+				t.Errorf("Expected category %q, got %q", tt.expectedCategory, category)
+			}
+		})
+	}
+}
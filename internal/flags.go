@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"reflect"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// FlagSnapshot keeps the values the flags in a command tree held before
+// an execution parsed them, as cobra keeps flag values between executions
+// of the same command
+type FlagSnapshot map[*pflag.Flag]flagState
+
+// The values are copied rather than formatted, as not every flag can
+// parse what it formats, and pflag's slices and maps also keep whether
+// they were set to append to them the next time
+type flagState []savedValue
+
+type savedValue struct {
+	target reflect.Value
+	saved  reflect.Value
+}
+
+// SnapshotFlags keeps the values of every flag in the command tree
+func SnapshotFlags(cmd *cobra.Command) FlagSnapshot {
+	snapshot := FlagSnapshot{}
+	snapshot.add(cmd)
+	return snapshot
+}
+
+func (s FlagSnapshot) add(cmd *cobra.Command) {
+	save := func(f *pflag.Flag) {
+		if _, ok := s[f]; !ok {
+			s[f] = saveFlagValue(f.Value)
+		}
+	}
+	cmd.Flags().VisitAll(save)
+	cmd.PersistentFlags().VisitAll(save)
+
+	for _, child := range cmd.Commands() {
+		s.add(child)
+	}
+}
+
+// Restore puts every flag the last execution set in the command tree back
+// to the value it held before that execution, and forgets it was set.
+// Flags added during the execution, like cobra's help flag, go back to
+// their default.
+func (s FlagSnapshot) Restore(cmd *cobra.Command) {
+	restore := func(f *pflag.Flag) {
+		if !f.Changed {
+			return
+		}
+		if state, ok := s[f]; ok {
+			state.restore()
+		} else {
+			f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	}
+	cmd.Flags().VisitAll(restore)
+	cmd.PersistentFlags().VisitAll(restore)
+
+	for _, child := range cmd.Commands() {
+		s.Restore(child)
+	}
+}
+
+// Copies what the flag's value points to. pflag's values are either a
+// pointer to the variable itself, or a struct holding that pointer, so
+// anything those fields point to is copied too.
+func saveFlagValue(value pflag.Value) flagState {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return nil
+	}
+
+	elem := v.Elem()
+	state := flagState{saveValue(elem)}
+	if elem.Kind() != reflect.Struct {
+		return state
+	}
+	for i := 0; i < elem.NumField(); i++ {
+		field := elem.Field(i)
+		if field.Kind() != reflect.Pointer || field.IsNil() || field.Type().Elem().Kind() == reflect.Struct {
+			continue
+		}
+		// pflag's fields are unexported, so can only be set through
+		// the address they point to
+		pointee := reflect.NewAt(field.Type().Elem(), field.UnsafePointer()).Elem()
+		state = append(state, saveValue(pointee))
+	}
+	return state
+}
+
+func saveValue(target reflect.Value) savedValue {
+	saved := reflect.New(target.Type()).Elem()
+	saved.Set(target)
+	return savedValue{target: target, saved: saved}
+}
+
+func (s flagState) restore() {
+	for _, v := range s {
+		v.target.Set(v.saved)
+	}
+}
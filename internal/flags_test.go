@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+// A flag that formats its value differently to how it's parsed
+type levelValue struct {
+	level int
+}
+
+func (l *levelValue) String() string { return fmt.Sprintf("level %d", l.level) }
+
+func (l *levelValue) Set(s string) error {
+	l.level = strings.Count(s, "+")
+	return nil
+}
+
+func (l *levelValue) Type() string { return "level" }
+
+func TestFlagSnapshot(t *testing.T) {
+	var (
+		verbose bool
+		region  string
+		tags    []string
+		labels  map[string]string
+		level   = &levelValue{level: 1}
+	)
+
+	root := &cobra.Command{Use: "myapp", Run: func(cmd *cobra.Command, args []string) {}}
+	root.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
+	sub := &cobra.Command{Use: "sub", Run: func(cmd *cobra.Command, args []string) {}}
+	sub.Flags().StringVar(&region, "region", "us-east-1", "a region")
+	sub.Flags().StringSliceVar(&tags, "tags", []string{"a", "b"}, "tags")
+	sub.Flags().StringToStringVar(&labels, "labels", map[string]string{}, "labels")
+	sub.Flags().Var(level, "level", "a level")
+	root.AddCommand(sub)
+
+	// set before executing, which is kept
+	if err := sub.Flags().Set("region", "eu-west-1"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	snapshot := SnapshotFlags(root)
+	root.SetArgs([]string{"sub", "-v", "--region", "ap-south-1", "--tags", "x", "--labels", "k=v", "--level", "+++"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !verbose || region != "ap-south-1" || !slices.Equal(tags, []string{"x"}) || labels["k"] != "v" || level.level != 3 {
+		t.Fatalf("Expected the flags to be parsed, got %v %v %v %v %v", verbose, region, tags, labels, level.level)
+	}
+
+	snapshot.Restore(root)
+
+	tests := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{"verbose", verbose, false},
+		{"region", region, "eu-west-1"},
+		{"tags", strings.Join(tags, ","), "a,b"},
+		{"labels", len(labels), 0},
+		{"level", level.level, 1},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("Restore() left %s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+		flag := sub.Flags().Lookup(tt.name)
+		if flag == nil {
+			flag = root.PersistentFlags().Lookup(tt.name)
+		}
+		if flag.Changed {
+			t.Errorf("Expected %s not to be changed after Restore()", tt.name)
+		}
+	}
+
+	// slices are replaced rather than appended to by the next execution
+	root.SetArgs([]string{"sub", "--tags", "y"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !slices.Equal(tags, []string{"y"}) {
+		t.Errorf("tags = %v, want [y]", tags)
+	}
+}
@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const DefaultInventoryCommand = "__metrics-inventory"
+
+// Marks the command added by AddInventoryCommand, which is left out of the
+// inventory as it isn't part of the CLI's own surface
+const inventoryCommandAnnotation = "otel.metrics/inventory"
+
+var ErrInventoryCommandExists = errors.New("a command with the inventory command name already exists")
+
+// Inventory is the full surface of a CLI, so usage metrics can be
+// joined against it to find commands and flags that are never used
+type Inventory struct {
+	Name     string             `json:"name"`
+	Version  string             `json:"version,omitempty"`
+	Commands []InventoryCommand `json:"commands"`
+}
+
+// InventoryCommand describes a single command. Command matches the
+// "command" attribute on the invocation metric.
+type InventoryCommand struct {
+	Command           string          `json:"command"`
+	Path              string          `json:"path"`
+	Aliases           []string        `json:"aliases,omitempty"`
+	Hidden            bool            `json:"hidden"`
+	Deprecated        bool            `json:"deprecated"`
+	TelemetryDisabled bool            `json:"telemetry_disabled"`
+	Flags             []InventoryFlag `json:"flags"`
+}
+
+// InventoryFlag describes a flag defined on a command. Flags inherited
+// from parents are only listed on the command that defines them.
+type InventoryFlag struct {
+	Name                string `json:"name"`
+	Shorthand           string `json:"shorthand,omitempty"`
+	Persistent          bool   `json:"persistent"`
+	Hidden              bool   `json:"hidden"`
+	Deprecated          bool   `json:"deprecated"`
+	ShorthandDeprecated bool   `json:"shorthand_deprecated"`
+}
+
+// Walks the whole command tree from the root command and builds
+// an inventory of every command and flag
+func BuildInventory(cmd *cobra.Command) Inventory {
+	root := cmd.Root()
+	inventory := Inventory{
+		Name:     root.Name(),
+		Version:  root.Version,
+		Commands: []InventoryCommand{},
+	}
+
+	var walk func(c *cobra.Command)
+	walk = func(c *cobra.Command) {
+		if _, ok := c.Annotations[inventoryCommandAnnotation]; ok {
+			return
+		}
+		inventory.Commands = append(inventory.Commands, buildInventoryCommand(c))
+		for _, child := range c.Commands() {
+			walk(child)
+		}
+	}
+	walk(root)
+
+	return inventory
+}
+
+func buildInventoryCommand(cmd *cobra.Command) InventoryCommand {
+	inventoryCmd := InventoryCommand{
+		Command:           ParseCmdName(cmd),
+		Path:              cmd.CommandPath(),
+		Aliases:           cmd.Aliases,
+		Hidden:            cmd.Hidden,
+		Deprecated:        cmd.Deprecated != "",
+		TelemetryDisabled: IsCommandTelemetryDisabled(cmd),
+		Flags:             []InventoryFlag{},
+	}
+
+	persistentFlags := cmd.PersistentFlags()
+	cmd.LocalFlags().VisitAll(func(f *pflag.Flag) {
+		if _, excluded := f.Annotations[ExcludeFlagAnnotation]; excluded {
+			return
+		}
+		inventoryCmd.Flags = append(inventoryCmd.Flags, InventoryFlag{
+			Name:                f.Name,
+			Shorthand:           f.Shorthand,
+			Persistent:          persistentFlags.Lookup(f.Name) != nil,
+			Hidden:              f.Hidden,
+			Deprecated:          f.Deprecated != "",
+			ShorthandDeprecated: f.ShorthandDeprecated != "",
+		})
+	})
+
+	return inventoryCmd
+}
+
+// Adds the configured hidden inventory command to the root command,
+// which prints the inventory as JSON. The command never records
+// telemetry itself.
+func AddInventoryCommand(cmd *cobra.Command, config *Config) error {
+	if config.InventoryCommand == "" {
+		return nil
+	}
+
+	for _, child := range cmd.Commands() {
+		if child.Name() == config.InventoryCommand {
+			return fmt.Errorf("%w: %s", ErrInventoryCommandExists, config.InventoryCommand)
+		}
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:    config.InventoryCommand,
+		Short:  "Print an inventory of all commands and flags as JSON",
+		Args:   cobra.NoArgs,
+		Hidden: true,
+		Annotations: map[string]string{
+			AnnotationDisabled:         "true",
+			inventoryCommandAnnotation: "true",
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			encoder := json.NewEncoder(cmd.OutOrStdout())
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(BuildInventory(cmd))
+		},
+	})
+	return nil
+}
@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func newInventoryCmdTree() (root, sub *cobra.Command) {
+	root = &cobra.Command{Use: "myapp", Version: "1.2.3"}
+	root.PersistentFlags().Bool("verbose", false, "verbose output")
+	sub = &cobra.Command{
+		Use:        "delete",
+		Aliases:    []string{"rm"},
+		Deprecated: "use remove instead",
+		Run:        func(cmd *cobra.Command, args []string) {},
+	}
+	sub.Flags().StringP("output", "o", "", "output format")
+	sub.Flags().MarkShorthandDeprecated("output", "use --output")
+	sub.Flags().Bool("internal", false, "internal use only")
+	sub.Flags().MarkHidden("internal")
+	root.AddCommand(sub)
+	return root, sub
+}
+
+func TestBuildInventory(t *testing.T) {
+	root, sub := newInventoryCmdTree()
+
+	inventory := BuildInventory(sub)
+
+	if inventory.Name != "myapp" {
+		t.Errorf("Expected name 'myapp', got %s", inventory.Name)
+	}
+
+	if inventory.Version != "1.2.3" {
+		t.Errorf("Expected version '1.2.3', got %s", inventory.Version)
+	}
+
+	if len(inventory.Commands) != 2 {
+		t.Fatalf("Expected 2 commands, got %d", len(inventory.Commands))
+	}
+
+	rootCmd := inventory.Commands[0]
+	if rootCmd.Command != "root" || rootCmd.Path != root.CommandPath() {
+		t.Errorf("Unexpected root command %+v", rootCmd)
+	}
+	if len(rootCmd.Flags) != 1 || rootCmd.Flags[0].Name != "verbose" || !rootCmd.Flags[0].Persistent {
+		t.Errorf("Unexpected root command flags %+v", rootCmd.Flags)
+	}
+
+	subCmd := inventory.Commands[1]
+	if subCmd.Command != "delete" || !subCmd.Deprecated || len(subCmd.Aliases) != 1 {
+		t.Errorf("Unexpected subcommand %+v", subCmd)
+	}
+
+	flags := map[string]InventoryFlag{}
+	for _, f := range subCmd.Flags {
+		flags[f.Name] = f
+	}
+	if len(flags) != 2 {
+		t.Fatalf("Expected 2 subcommand flags, got %+v", subCmd.Flags)
+	}
+	if output := flags["output"]; output.Shorthand != "o" || !output.ShorthandDeprecated || output.Persistent {
+		t.Errorf("Unexpected output flag %+v", output)
+	}
+	if !flags["internal"].Hidden {
+		t.Error("Expected internal flag to be hidden")
+	}
+}
+
+func TestAddInventoryCommand(t *testing.T) {
+	root, _ := newInventoryCmdTree()
+	config := &Config{InventoryCommand: DefaultInventoryCommand}
+
+	if err := AddInventoryCommand(root, config); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	out := &bytes.Buffer{}
+	root.SetOut(out)
+	root.SetArgs([]string{DefaultInventoryCommand})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	inventory := Inventory{}
+	if err := json.Unmarshal(out.Bytes(), &inventory); err != nil {
+		t.Fatalf("Expected inventory JSON, got error: %v", err)
+	}
+
+	commands := map[string]InventoryCommand{}
+	for _, c := range inventory.Commands {
+		commands[c.Command] = c
+	}
+	if _, ok := commands["delete"]; !ok {
+		t.Error("Expected the CLI's own commands to be listed")
+	}
+	if _, ok := commands[DefaultInventoryCommand]; ok {
+		t.Error("Expected inventory command to be left out")
+	}
+
+	inventoryCmd, _, err := root.Find([]string{DefaultInventoryCommand})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !inventoryCmd.Hidden || !IsCommandTelemetryDisabled(inventoryCmd) {
+		t.Errorf("Expected inventory command to be hidden with telemetry disabled")
+	}
+
+	err = AddInventoryCommand(root, config)
+	if !errors.Is(err, ErrInventoryCommandExists) {
+		t.Errorf("Expected ErrInventoryCommandExists, got %v", err)
+	}
+}
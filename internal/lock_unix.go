@@ -0,0 +1,28 @@
+//go:build unix
+
+package internal
+
+import "golang.org/x/sys/unix"
+
+// lockFile takes an exclusive lock next to the file, blocking until any
+// other process holding it lets go
+func lockFile(filePath string) (unlock func(), err error) {
+	f, err := openLockFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		err = unix.Flock(int(f.Fd()), unix.LOCK_EX)
+		if err != unix.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		unix.Flock(int(f.Fd()), unix.LOCK_UN)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,23 @@
+//go:build windows
+
+package internal
+
+import "golang.org/x/sys/windows"
+
+// lockFile takes an exclusive lock next to the file, blocking until any
+// other process holding it lets go
+func lockFile(filePath string) (unlock func(), err error) {
+	f, err := openLockFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	handle := windows.Handle(f.Fd())
+	if err := windows.LockFileEx(handle, windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, &windows.Overlapped{}); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		windows.UnlockFileEx(handle, 0, 1, 0, &windows.Overlapped{})
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,465 @@
+package internal
+
+import (
+	"context"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// attributeMeter wraps a meter so every attribute set recorded by its
+// instruments, observable ones included, is run through the processors
+// before it reaches the SDK. Nothing is recorded while paused is set.
+type attributeMeter struct {
+	metric.Meter
+	processors []AttributeProcessor
+	paused     *atomic.Bool
+}
+
+func newAttributeMeter(meter metric.Meter, paused *atomic.Bool, processors ...AttributeProcessor) metric.Meter {
+	if paused == nil && len(processors) == 0 {
+		return meter
+	}
+	return &attributeMeter{
+		Meter:      meter,
+		processors: processors,
+		paused:     paused,
+	}
+}
+
+func (m *attributeMeter) recording() bool {
+	return m.paused == nil || !m.paused.Load()
+}
+
+func (m *attributeMeter) process(name string, set attribute.Set) attribute.Set {
+	for _, process := range m.processors {
+		set = process(name, set)
+	}
+	return set
+}
+
+func (m *attributeMeter) addOptions(name string, opts []metric.AddOption) []metric.AddOption {
+	set := m.process(name, metric.NewAddConfig(opts).Attributes())
+	return []metric.AddOption{metric.WithAttributeSet(set)}
+}
+
+func (m *attributeMeter) recordOptions(name string, opts []metric.RecordOption) []metric.RecordOption {
+	set := m.process(name, metric.NewRecordConfig(opts).Attributes())
+	return []metric.RecordOption{metric.WithAttributeSet(set)}
+}
+
+func (m *attributeMeter) observeOptions(name string, opts []metric.ObserveOption) []metric.ObserveOption {
+	set := m.process(name, metric.NewObserveConfig(opts).Attributes())
+	return []metric.ObserveOption{metric.WithAttributeSet(set)}
+}
+
+// Wraps observable instrument callbacks so their observations are processed
+func (m *attributeMeter) int64Callbacks(name string, callbacks []metric.Int64Callback) []metric.Int64ObservableOption {
+	opts := make([]metric.Int64ObservableOption, 0, len(callbacks))
+	for _, callback := range callbacks {
+		opts = append(opts, metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			if !m.recording() {
+				return nil
+			}
+			return callback(ctx, &int64Observer{Int64Observer: o, meter: m, name: name})
+		}))
+	}
+	return opts
+}
+
+func (m *attributeMeter) float64Callbacks(name string, callbacks []metric.Float64Callback) []metric.Float64ObservableOption {
+	opts := make([]metric.Float64ObservableOption, 0, len(callbacks))
+	for _, callback := range callbacks {
+		opts = append(opts, metric.WithFloat64Callback(func(ctx context.Context, o metric.Float64Observer) error {
+			if !m.recording() {
+				return nil
+			}
+			return callback(ctx, &float64Observer{Float64Observer: o, meter: m, name: name})
+		}))
+	}
+	return opts
+}
+
+func (m *attributeMeter) Int64Counter(name string, options ...metric.Int64CounterOption) (metric.Int64Counter, error) {
+	counter, err := m.Meter.Int64Counter(name, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &int64Counter{Int64Counter: counter, meter: m, name: name}, nil
+}
+
+func (m *attributeMeter) Int64UpDownCounter(name string, options ...metric.Int64UpDownCounterOption) (metric.Int64UpDownCounter, error) {
+	counter, err := m.Meter.Int64UpDownCounter(name, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &int64UpDownCounter{Int64UpDownCounter: counter, meter: m, name: name}, nil
+}
+
+func (m *attributeMeter) Int64Histogram(name string, options ...metric.Int64HistogramOption) (metric.Int64Histogram, error) {
+	histogram, err := m.Meter.Int64Histogram(name, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &int64Histogram{Int64Histogram: histogram, meter: m, name: name}, nil
+}
+
+func (m *attributeMeter) Int64Gauge(name string, options ...metric.Int64GaugeOption) (metric.Int64Gauge, error) {
+	gauge, err := m.Meter.Int64Gauge(name, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &int64Gauge{Int64Gauge: gauge, meter: m, name: name}, nil
+}
+
+func (m *attributeMeter) Int64ObservableCounter(name string, options ...metric.Int64ObservableCounterOption) (metric.Int64ObservableCounter, error) {
+	cfg := metric.NewInt64ObservableCounterConfig(options...)
+	opts := []metric.Int64ObservableCounterOption{
+		metric.WithDescription(cfg.Description()),
+		metric.WithUnit(cfg.Unit()),
+	}
+	for _, opt := range m.int64Callbacks(name, cfg.Callbacks()) {
+		opts = append(opts, opt)
+	}
+	counter, err := m.Meter.Int64ObservableCounter(name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &int64ObservableCounter{Int64ObservableCounter: counter, name: name}, nil
+}
+
+func (m *attributeMeter) Int64ObservableUpDownCounter(name string, options ...metric.Int64ObservableUpDownCounterOption) (metric.Int64ObservableUpDownCounter, error) {
+	cfg := metric.NewInt64ObservableUpDownCounterConfig(options...)
+	opts := []metric.Int64ObservableUpDownCounterOption{
+		metric.WithDescription(cfg.Description()),
+		metric.WithUnit(cfg.Unit()),
+	}
+	for _, opt := range m.int64Callbacks(name, cfg.Callbacks()) {
+		opts = append(opts, opt)
+	}
+	counter, err := m.Meter.Int64ObservableUpDownCounter(name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &int64ObservableUpDownCounter{Int64ObservableUpDownCounter: counter, name: name}, nil
+}
+
+func (m *attributeMeter) Int64ObservableGauge(name string, options ...metric.Int64ObservableGaugeOption) (metric.Int64ObservableGauge, error) {
+	cfg := metric.NewInt64ObservableGaugeConfig(options...)
+	opts := []metric.Int64ObservableGaugeOption{
+		metric.WithDescription(cfg.Description()),
+		metric.WithUnit(cfg.Unit()),
+	}
+	for _, opt := range m.int64Callbacks(name, cfg.Callbacks()) {
+		opts = append(opts, opt)
+	}
+	gauge, err := m.Meter.Int64ObservableGauge(name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &int64ObservableGauge{Int64ObservableGauge: gauge, name: name}, nil
+}
+
+func (m *attributeMeter) Float64Counter(name string, options ...metric.Float64CounterOption) (metric.Float64Counter, error) {
+	counter, err := m.Meter.Float64Counter(name, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &float64Counter{Float64Counter: counter, meter: m, name: name}, nil
+}
+
+func (m *attributeMeter) Float64UpDownCounter(name string, options ...metric.Float64UpDownCounterOption) (metric.Float64UpDownCounter, error) {
+	counter, err := m.Meter.Float64UpDownCounter(name, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &float64UpDownCounter{Float64UpDownCounter: counter, meter: m, name: name}, nil
+}
+
+func (m *attributeMeter) Float64Histogram(name string, options ...metric.Float64HistogramOption) (metric.Float64Histogram, error) {
+	histogram, err := m.Meter.Float64Histogram(name, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &float64Histogram{Float64Histogram: histogram, meter: m, name: name}, nil
+}
+
+func (m *attributeMeter) Float64Gauge(name string, options ...metric.Float64GaugeOption) (metric.Float64Gauge, error) {
+	gauge, err := m.Meter.Float64Gauge(name, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &float64Gauge{Float64Gauge: gauge, meter: m, name: name}, nil
+}
+
+func (m *attributeMeter) Float64ObservableCounter(name string, options ...metric.Float64ObservableCounterOption) (metric.Float64ObservableCounter, error) {
+	cfg := metric.NewFloat64ObservableCounterConfig(options...)
+	opts := []metric.Float64ObservableCounterOption{
+		metric.WithDescription(cfg.Description()),
+		metric.WithUnit(cfg.Unit()),
+	}
+	for _, opt := range m.float64Callbacks(name, cfg.Callbacks()) {
+		opts = append(opts, opt)
+	}
+	counter, err := m.Meter.Float64ObservableCounter(name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &float64ObservableCounter{Float64ObservableCounter: counter, name: name}, nil
+}
+
+func (m *attributeMeter) Float64ObservableUpDownCounter(name string, options ...metric.Float64ObservableUpDownCounterOption) (metric.Float64ObservableUpDownCounter, error) {
+	cfg := metric.NewFloat64ObservableUpDownCounterConfig(options...)
+	opts := []metric.Float64ObservableUpDownCounterOption{
+		metric.WithDescription(cfg.Description()),
+		metric.WithUnit(cfg.Unit()),
+	}
+	for _, opt := range m.float64Callbacks(name, cfg.Callbacks()) {
+		opts = append(opts, opt)
+	}
+	counter, err := m.Meter.Float64ObservableUpDownCounter(name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &float64ObservableUpDownCounter{Float64ObservableUpDownCounter: counter, name: name}, nil
+}
+
+func (m *attributeMeter) Float64ObservableGauge(name string, options ...metric.Float64ObservableGaugeOption) (metric.Float64ObservableGauge, error) {
+	cfg := metric.NewFloat64ObservableGaugeConfig(options...)
+	opts := []metric.Float64ObservableGaugeOption{
+		metric.WithDescription(cfg.Description()),
+		metric.WithUnit(cfg.Unit()),
+	}
+	for _, opt := range m.float64Callbacks(name, cfg.Callbacks()) {
+		opts = append(opts, opt)
+	}
+	gauge, err := m.Meter.Float64ObservableGauge(name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &float64ObservableGauge{Float64ObservableGauge: gauge, name: name}, nil
+}
+
+// RegisterCallback unwraps the instruments for the SDK, which only accepts
+// its own, and processes everything observed through the callback
+func (m *attributeMeter) RegisterCallback(f metric.Callback, instruments ...metric.Observable) (metric.Registration, error) {
+	unwrapped := make([]metric.Observable, 0, len(instruments))
+	for _, instrument := range instruments {
+		if w, ok := instrument.(wrappedObservable); ok {
+			instrument = w.unwrap()
+		}
+		unwrapped = append(unwrapped, instrument)
+	}
+
+	return m.Meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		if !m.recording() {
+			return nil
+		}
+		return f(ctx, &observer{Observer: o, meter: m})
+	}, unwrapped...)
+}
+
+// wrappedObservable is implemented by the observable instruments returned
+// from attributeMeter
+type wrappedObservable interface {
+	instrumentName() string
+	unwrap() metric.Observable
+}
+
+type observer struct {
+	metric.Observer
+	meter *attributeMeter
+}
+
+func (o *observer) ObserveInt64(obsrv metric.Int64Observable, value int64, opts ...metric.ObserveOption) {
+	if w, ok := obsrv.(wrappedObservable); ok {
+		opts = o.meter.observeOptions(w.instrumentName(), opts)
+		obsrv = w.unwrap().(metric.Int64Observable)
+	}
+	o.Observer.ObserveInt64(obsrv, value, opts...)
+}
+
+func (o *observer) ObserveFloat64(obsrv metric.Float64Observable, value float64, opts ...metric.ObserveOption) {
+	if w, ok := obsrv.(wrappedObservable); ok {
+		opts = o.meter.observeOptions(w.instrumentName(), opts)
+		obsrv = w.unwrap().(metric.Float64Observable)
+	}
+	o.Observer.ObserveFloat64(obsrv, value, opts...)
+}
+
+type int64Observer struct {
+	metric.Int64Observer
+	meter *attributeMeter
+	name  string
+}
+
+func (o *int64Observer) Observe(value int64, opts ...metric.ObserveOption) {
+	o.Int64Observer.Observe(value, o.meter.observeOptions(o.name, opts)...)
+}
+
+type float64Observer struct {
+	metric.Float64Observer
+	meter *attributeMeter
+	name  string
+}
+
+func (o *float64Observer) Observe(value float64, opts ...metric.ObserveOption) {
+	o.Float64Observer.Observe(value, o.meter.observeOptions(o.name, opts)...)
+}
+
+type int64Counter struct {
+	metric.Int64Counter
+	meter *attributeMeter
+	name  string
+}
+
+func (i *int64Counter) Add(ctx context.Context, incr int64, opts ...metric.AddOption) {
+	if !i.meter.recording() {
+		return
+	}
+	i.Int64Counter.Add(ctx, incr, i.meter.addOptions(i.name, opts)...)
+}
+
+type int64UpDownCounter struct {
+	metric.Int64UpDownCounter
+	meter *attributeMeter
+	name  string
+}
+
+func (i *int64UpDownCounter) Add(ctx context.Context, incr int64, opts ...metric.AddOption) {
+	if !i.meter.recording() {
+		return
+	}
+	i.Int64UpDownCounter.Add(ctx, incr, i.meter.addOptions(i.name, opts)...)
+}
+
+type int64Histogram struct {
+	metric.Int64Histogram
+	meter *attributeMeter
+	name  string
+}
+
+func (i *int64Histogram) Record(ctx context.Context, value int64, opts ...metric.RecordOption) {
+	if !i.meter.recording() {
+		return
+	}
+	i.Int64Histogram.Record(ctx, value, i.meter.recordOptions(i.name, opts)...)
+}
+
+type int64Gauge struct {
+	metric.Int64Gauge
+	meter *attributeMeter
+	name  string
+}
+
+func (i *int64Gauge) Record(ctx context.Context, value int64, opts ...metric.RecordOption) {
+	if !i.meter.recording() {
+		return
+	}
+	i.Int64Gauge.Record(ctx, value, i.meter.recordOptions(i.name, opts)...)
+}
+
+type float64Counter struct {
+	metric.Float64Counter
+	meter *attributeMeter
+	name  string
+}
+
+func (i *float64Counter) Add(ctx context.Context, incr float64, opts ...metric.AddOption) {
+	if !i.meter.recording() {
+		return
+	}
+	i.Float64Counter.Add(ctx, incr, i.meter.addOptions(i.name, opts)...)
+}
+
+type float64UpDownCounter struct {
+	metric.Float64UpDownCounter
+	meter *attributeMeter
+	name  string
+}
+
+func (i *float64UpDownCounter) Add(ctx context.Context, incr float64, opts ...metric.AddOption) {
+	if !i.meter.recording() {
+		return
+	}
+	i.Float64UpDownCounter.Add(ctx, incr, i.meter.addOptions(i.name, opts)...)
+}
+
+type float64Histogram struct {
+	metric.Float64Histogram
+	meter *attributeMeter
+	name  string
+}
+
+func (i *float64Histogram) Record(ctx context.Context, value float64, opts ...metric.RecordOption) {
+	if !i.meter.recording() {
+		return
+	}
+	i.Float64Histogram.Record(ctx, value, i.meter.recordOptions(i.name, opts)...)
+}
+
+type float64Gauge struct {
+	metric.Float64Gauge
+	meter *attributeMeter
+	name  string
+}
+
+func (i *float64Gauge) Record(ctx context.Context, value float64, opts ...metric.RecordOption) {
+	if !i.meter.recording() {
+		return
+	}
+	i.Float64Gauge.Record(ctx, value, i.meter.recordOptions(i.name, opts)...)
+}
+
+type int64ObservableCounter struct {
+	metric.Int64ObservableCounter
+	name string
+}
+
+func (i *int64ObservableCounter) instrumentName() string    { return i.name }
+func (i *int64ObservableCounter) unwrap() metric.Observable { return i.Int64ObservableCounter }
+
+type int64ObservableUpDownCounter struct {
+	metric.Int64ObservableUpDownCounter
+	name string
+}
+
+func (i *int64ObservableUpDownCounter) instrumentName() string { return i.name }
+func (i *int64ObservableUpDownCounter) unwrap() metric.Observable {
+	return i.Int64ObservableUpDownCounter
+}
+
+type int64ObservableGauge struct {
+	metric.Int64ObservableGauge
+	name string
+}
+
+func (i *int64ObservableGauge) instrumentName() string    { return i.name }
+func (i *int64ObservableGauge) unwrap() metric.Observable { return i.Int64ObservableGauge }
+
+type float64ObservableCounter struct {
+	metric.Float64ObservableCounter
+	name string
+}
+
+func (i *float64ObservableCounter) instrumentName() string    { return i.name }
+func (i *float64ObservableCounter) unwrap() metric.Observable { return i.Float64ObservableCounter }
+
+type float64ObservableUpDownCounter struct {
+	metric.Float64ObservableUpDownCounter
+	name string
+}
+
+func (i *float64ObservableUpDownCounter) instrumentName() string { return i.name }
+func (i *float64ObservableUpDownCounter) unwrap() metric.Observable {
+	return i.Float64ObservableUpDownCounter
+}
+
+type float64ObservableGauge struct {
+	metric.Float64ObservableGauge
+	name string
+}
+
+func (i *float64ObservableGauge) instrumentName() string    { return i.name }
+func (i *float64ObservableGauge) unwrap() metric.Observable { return i.Float64ObservableGauge }
@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	metricSdk "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// Adds a "processed" attribute to every set recorded
+func markProcessed(instrument string, set attribute.Set) attribute.Set {
+	attributes := append(set.ToSlice(), attribute.String("processed", instrument))
+	return attribute.NewSet(attributes...)
+}
+
+func collectAttributes(t *testing.T, reader *metricSdk.ManualReader) map[string][]attribute.Set {
+	t.Helper()
+
+	collected := &metricdata.ResourceMetrics{}
+	if err := reader.Collect(context.Background(), collected); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	sets := map[string][]attribute.Set{}
+	for _, scope := range collected.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, point := range data.DataPoints {
+					sets[m.Name] = append(sets[m.Name], point.Attributes)
+				}
+			case metricdata.Gauge[float64]:
+				for _, point := range data.DataPoints {
+					sets[m.Name] = append(sets[m.Name], point.Attributes)
+				}
+			case metricdata.Histogram[float64]:
+				for _, point := range data.DataPoints {
+					sets[m.Name] = append(sets[m.Name], point.Attributes)
+				}
+			}
+		}
+	}
+	return sets
+}
+
+func TestAttributeMeter(t *testing.T) {
+	ctx := context.Background()
+	reader := metricSdk.NewManualReader()
+	provider := metricSdk.NewMeterProvider(metricSdk.WithReader(reader))
+	defer provider.Shutdown(ctx)
+
+	meter := newAttributeMeter(provider.Meter("test"), nil, markProcessed)
+
+	counter, err := meter.Int64Counter("counter")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	counter.Add(ctx, 1, metric.WithAttributes(attribute.String("command", "root")))
+
+	histogram, err := meter.Float64Histogram("histogram")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	histogram.Record(ctx, 1.5)
+
+	_, err = meter.Float64ObservableGauge("observable", metric.WithFloat64Callback(
+		func(ctx context.Context, o metric.Float64Observer) error {
+			o.Observe(1)
+			return nil
+		},
+	))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	registered, err := meter.Int64ObservableCounter("registered")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, err = meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		o.ObserveInt64(registered, 2)
+		return nil
+	}, registered)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	sets := collectAttributes(t, reader)
+	for _, name := range []string{"counter", "histogram", "observable", "registered"} {
+		if len(sets[name]) != 1 {
+			t.Errorf("Expected 1 data point for %s, got %d", name, len(sets[name]))
+			continue
+		}
+		if value, ok := sets[name][0].Value("processed"); !ok || value.AsString() != name {
+			t.Errorf("Expected %s to be processed, got %v", name, sets[name][0].Encoded(attribute.DefaultEncoder()))
+		}
+	}
+}
+
+func TestNewAttributeMeterWithoutProcessors(t *testing.T) {
+	provider := metricSdk.NewMeterProvider()
+	meter := provider.Meter("test")
+
+	if got := newAttributeMeter(meter, nil); got != meter {
+		t.Error("Expected meter to be returned unwrapped")
+	}
+}
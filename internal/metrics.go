@@ -2,9 +2,17 @@ package internal
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync/atomic"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/cobra"
 	"go.opentelemetry.io/otel/metric"
 	metricSdk "go.opentelemetry.io/otel/sdk/metric"
@@ -16,40 +24,181 @@ import (
 type Config struct {
 	ServiceName string
 	Exporters   []metricSdk.Exporter
+
+	// Where the consent prompt reads answers from and writes to. When
+	// unset these default to the command's InOrStdin and ErrOrStderr.
+	ConsentInput  io.Reader
+	ConsentOutput io.Writer
+
+	// How long to wait for an answer before defaulting to "no" without
+	// saving. Zero waits forever.
+	ConsentTimeout time.Duration
+
+	// How many times an invalid answer is re-asked before giving up.
+	ConsentMaxRetries int
+
+	// How consent is decided for non-interactive sessions
+	ConsentMode ConsentMode
+
+	// Environment variable and flag that explicitly opt a session in to
+	// metric collection when running in ConsentModeStrict
+	OptInEnvVar string
+	OptInFlag   string
+
+	// Persistent flag added to the root command that disables telemetry
+	// for a single invocation. Empty means no flag is added.
+	NoTelemetryFlag       string
+	NoTelemetryFlagHidden bool
+
+	// Whether shell completion requests are recorded as their own metric.
+	// When false completion requests record and export nothing.
+	CompletionMetrics bool
+
+	// Hidden command added to the root command which prints the
+	// command inventory. Empty means no command is added.
+	InventoryCommand string
+
+	// Added to every invocation as the "session" attribute to tie
+	// together the commands run in a single process, e.g. a REPL.
+	// Empty means no session attribute is added.
+	SessionID string
+
+	// How often metrics are exported while a long running command runs
+	ExportInterval time.Duration
+
+	// Address to serve the Prometheus /metrics endpoint on for commands
+	// marked with AnnotationPrometheus. Empty means it's never served.
+	PrometheusAddress string
+
+	// Preferred temporality of the exported metrics
+	Temporality Temporality
+
+	// Picks the aggregation for each kind of instrument. Nil uses the
+	// SDK's default aggregations.
+	AggregationSelector metricSdk.AggregationSelector
+
+	// Views registered on the provider to rename metrics, drop attributes
+	// or change histogram buckets
+	Views []metricSdk.View
+
+	// How the built-in metrics and their attributes are named
+	NamingScheme NamingScheme
+
+	// Replaces the name, description or unit of built-in metrics. Empty
+	// fields keep the naming scheme's value.
+	MetricOverrides map[BuiltinMetric]MetricDefinition
+
+	// Maximum distinct attribute sets recorded per metric in a single
+	// execution, any more are folded into the otel.metric.overflow series.
+	// Zero means no limit.
+	CardinalityLimit int
+
+	// Run in order over every attribute set recorded, before the
+	// cardinality limit is applied
+	AttributeProcessors []AttributeProcessor
+
+	// Attribute keys whose values are replaced with a hash salted with
+	// the per-install salt kept in SaltFile
+	HashedAttributes []string
+	SaltFile         string
+
+	// Rate between 0 and 1 that invocations are sampled at. Zero means
+	// unset, so every invocation is sampled.
+	SampleRate float64
+
+	// Maximum invocations sampled per RateLimitWindow, counted across
+	// processes in RateLimitFile. Zero means no limit.
+	RateLimit       int
+	RateLimitWindow time.Duration
+	RateLimitFile   string
+
+	// Merges every invocation's metrics into an aggregate kept in
+	// AggregateFile, only exporting a rollup once AggregationWindow has
+	// passed or the aggregate is over AggregationMaxSize bytes. Zero
+	// means every invocation is exported on its own.
+	AggregationWindow  time.Duration
+	AggregationMaxSize int
+	AggregateFile      string
+
+	// Whether the CPU time, max RSS and wall time of every invocation
+	// are recorded as histograms
+	ResourceMetrics bool
+
+	// Whether GC pauses, heap allocations, GC cycles and the goroutine
+	// high-water mark of every invocation are recorded as histograms
+	RuntimeMetrics bool
+
+	// Whether the time from process start to the command running, and
+	// from the command's Run completing to the process exiting, are
+	// recorded as histograms. Exit latency is only known once the process
+	// is done, so it's kept in ExitLatencyFile and reported next time.
+	StartupMetrics  bool
+	ExitLatencyFile string
 }
 
 type MetricsProvider struct {
 	config   *Config
 	Provider *metricSdk.MeterProvider
 	Meter    metric.Meter
+
+	// Collected from at the end of every execution to export to the
+	// configured exporters
+	Reader *metricSdk.ManualReader
+
+	// Only set when a cardinality limit is configured
+	limiter *cardinalityLimiter
+
+	// Only set when sampling is configured
+	sampler *sampler
+
+	// Only set when the Prometheus endpoint is configured
+	PrometheusRegistry *prometheus.Registry
+
+	// Set while the meter's instruments record nothing
+	paused *atomic.Bool
 }
 
 var (
-	Reader    = metricSdk.NewManualReader(metricSdk.WithTemporalitySelector(deltaSelector))
+	// The reader of the most recently created provider
+	Reader    *metricSdk.ManualReader
 	Exporters []metricSdk.Exporter
 )
 
-func deltaSelector(kind metricSdk.InstrumentKind) metricdata.Temporality {
-	switch kind {
-	case metricSdk.InstrumentKindCounter,
-		metricSdk.InstrumentKindGauge,
-		metricSdk.InstrumentKindHistogram,
-		metricSdk.InstrumentKindObservableGauge,
-		metricSdk.InstrumentKindObservableCounter:
-		return metricdata.DeltaTemporality
-	case metricSdk.InstrumentKindUpDownCounter,
-		metricSdk.InstrumentKindObservableUpDownCounter:
-		return metricdata.CumulativeTemporality
-	default:
-		return metricdata.CumulativeTemporality
-	}
-}
+var (
+	ErrServiceNameEmpty          = errors.New("Service Name cannot be empty")
+	ErrConsentTimeoutNegative    = errors.New("Consent Timeout cannot be negative")
+	ErrConsentMaxRetriesNegative = errors.New("Consent Max Retries cannot be negative")
+	ErrConsentModeUnknown        = errors.New("Consent Mode is unknown")
+	ErrExportIntervalNegative    = errors.New("Export Interval cannot be negative")
+	ErrTemporalityUnknown        = errors.New("Temporality is unknown")
+	ErrNamingSchemeUnknown       = errors.New("Naming Scheme is unknown")
+	ErrBuiltinMetricUnknown      = errors.New("Metric is not a built-in metric")
+	ErrCardinalityLimitNegative  = errors.New("Cardinality Limit cannot be negative")
+	ErrSampleRateInvalid         = errors.New("Sample Rate must be greater than 0 and at most 1")
+	ErrRateLimitNegative         = errors.New("Rate Limit cannot be negative")
+	ErrRateLimitWindowInvalid    = errors.New("Rate Limit Window must be positive")
+	ErrAggregationWindowNegative = errors.New("Aggregation Window cannot be negative")
+	ErrAggregationMaxSizeInvalid = errors.New("Aggregation Max Size cannot be negative")
+)
 
-var ErrServiceNameEmpty = errors.New("Service Name cannot be empty")
+const (
+	DefaultConsentTimeout    = 60 * time.Second
+	DefaultConsentMaxRetries = 3
+	DefaultExportInterval    = 60 * time.Second
+)
 
 func NewConfig(cmd *cobra.Command, opts ...Option) (*Config, error) {
 	config := &Config{
-		ServiceName: GetRootCmdName(cmd),
+		ServiceName:       GetRootCmdName(cmd),
+		ConsentTimeout:    DefaultConsentTimeout,
+		ConsentMaxRetries: DefaultConsentMaxRetries,
+		OptInEnvVar:       getDefaultOptInEnvVar(GetRootCmdName(cmd)),
+		ExportInterval:    DefaultExportInterval,
+		CardinalityLimit:  DefaultCardinalityLimit,
+		SaltFile:          getDefaultSaltFilePath(GetRootCmdName(cmd)),
+		RateLimitFile:     getDefaultRateLimitFilePath(GetRootCmdName(cmd)),
+		AggregateFile:     getDefaultAggregateFilePath(GetRootCmdName(cmd)),
+		ExitLatencyFile:   getDefaultExitLatencyFilePath(GetRootCmdName(cmd)),
 	}
 
 	for _, opt := range opts {
@@ -68,11 +217,53 @@ func NewConfig(cmd *cobra.Command, opts ...Option) (*Config, error) {
 }
 
 func (c *Config) validate() error {
+	var errs []error
 	if c.ServiceName == "" {
-		return errors.Join(ErrServiceNameEmpty)
+		errs = append(errs, ErrServiceNameEmpty)
+	}
+	if c.ConsentTimeout < 0 {
+		errs = append(errs, ErrConsentTimeoutNegative)
+	}
+	if c.ConsentMaxRetries < 0 {
+		errs = append(errs, ErrConsentMaxRetriesNegative)
+	}
+	if c.ConsentMode != ConsentModeDefault && c.ConsentMode != ConsentModeStrict {
+		errs = append(errs, ErrConsentModeUnknown)
+	}
+	if c.ExportInterval < 0 {
+		errs = append(errs, ErrExportIntervalNegative)
+	}
+	if c.Temporality.selector() == nil {
+		errs = append(errs, ErrTemporalityUnknown)
+	}
+	if c.NamingScheme != NamingSchemeLegacy && c.NamingScheme != NamingSchemeSemconv {
+		errs = append(errs, ErrNamingSchemeUnknown)
+	}
+	if c.CardinalityLimit < 0 {
+		errs = append(errs, ErrCardinalityLimitNegative)
+	}
+	if c.SampleRate < 0 || c.SampleRate > 1 {
+		errs = append(errs, ErrSampleRateInvalid)
+	}
+	if c.RateLimit < 0 {
+		errs = append(errs, ErrRateLimitNegative)
+	}
+	if c.RateLimit > 0 && c.RateLimitWindow <= 0 {
+		errs = append(errs, ErrRateLimitWindowInvalid)
+	}
+	if c.AggregationWindow < 0 {
+		errs = append(errs, ErrAggregationWindowNegative)
+	}
+	if c.AggregationMaxSize < 0 {
+		errs = append(errs, ErrAggregationMaxSizeInvalid)
+	}
+	for m := range c.MetricOverrides {
+		if _, ok := builtinMetrics[m]; !ok {
+			errs = append(errs, fmt.Errorf("%w: %s", ErrBuiltinMetricUnknown, m))
+		}
 	}
 
-	return nil
+	return errors.Join(errs...)
 }
 
 func NewMetricsProvider(ctx context.Context, config *Config) (*MetricsProvider, error) {
@@ -86,18 +277,75 @@ func NewMetricsProvider(ctx context.Context, config *Config) (*MetricsProvider,
 		return nil, fmt.Errorf("failed to create resource: %w", err)
 	}
 
-	provider := metricSdk.NewMeterProvider(
+	readerOptions := []metricSdk.ManualReaderOption{
+		metricSdk.WithTemporalitySelector(config.Temporality.selector()),
+	}
+	if config.AggregationSelector != nil {
+		readerOptions = append(readerOptions, metricSdk.WithAggregationSelector(config.AggregationSelector))
+	}
+	reader := metricSdk.NewManualReader(readerOptions...)
+	Reader = reader
+
+	providerOptions := []metricSdk.Option{
 		metricSdk.WithResource(res),
-		metricSdk.WithReader(Reader),
-	)
+		metricSdk.WithReader(reader),
+		metricSdk.WithView(config.Views...),
+	}
+
+	// The Prometheus endpoint is pulled from its own reader
+	// alongside the push exporters
+	var registry *prometheus.Registry
+	if config.PrometheusAddress != "" {
+		var prometheusReader metricSdk.Reader
+		registry, prometheusReader, err = newPrometheusReader(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create prometheus reader: %w", err)
+		}
+		providerOptions = append(providerOptions, metricSdk.WithReader(prometheusReader))
+	}
 
-	// Create meter
-	meter := provider.Meter("cobra-otel-metrics")
+	provider := metricSdk.NewMeterProvider(providerOptions...)
+
+	// Create meter, with every attribute set it records run through
+	// the processors first
+	var processors []AttributeProcessor
+	var sampler *sampler
+	if config.isSamplingConfigured() {
+		sampler = newSampler(config)
+		processors = append(processors, sampler.process)
+	}
+	processors = append(processors, config.AttributeProcessors...)
+	if len(config.HashedAttributes) > 0 {
+		// A salt that can't be kept still hashes the values, they just
+		// won't match other invocations'. Without any salt the values
+		// are dropped rather than recorded as is.
+		salt, err := loadOrCreateSalt(config.SaltFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error loading salt for hashed attributes: %v\n", err)
+		}
+		if salt != nil {
+			processors = append(processors, HashAttributes(salt, config.HashedAttributes...))
+		} else {
+			processors = append(processors, DropAttributes(config.HashedAttributes...))
+		}
+	}
+	var limiter *cardinalityLimiter
+	if config.CardinalityLimit > 0 {
+		limiter = newCardinalityLimiter(config.CardinalityLimit)
+		processors = append(processors, limiter.process)
+	}
+	paused := &atomic.Bool{}
+	meter := newAttributeMeter(provider.Meter("cobra-otel-metrics"), paused, processors...)
 
 	return &MetricsProvider{
-		config:   config,
-		Provider: provider,
-		Meter:    meter,
+		config:             config,
+		Provider:           provider,
+		Meter:              meter,
+		Reader:             reader,
+		limiter:            limiter,
+		sampler:            sampler,
+		PrometheusRegistry: registry,
+		paused:             paused,
 	}, nil
 }
 
@@ -105,6 +353,75 @@ func (mp *MetricsProvider) GetMeter() metric.Meter {
 	return mp.Meter
 }
 
+// SetRecording turns recording on or off for every instrument from the
+// meter. Every reader, the Prometheus one included, aggregates whatever
+// is recorded, so executions that aren't collected record nothing rather
+// than being dropped from a single reader's export.
+func (mp *MetricsProvider) SetRecording(recording bool) {
+	mp.paused.Store(!recording)
+}
+
+// ResetCardinalityLimit starts counting distinct attribute sets towards
+// the cardinality limit from scratch, for the start of an execution
+func (mp *MetricsProvider) ResetCardinalityLimit() {
+	if mp.limiter != nil {
+		mp.limiter.reset()
+	}
+}
+
+// Sample decides once per invocation if it's sampled, recording the rate
+// it was sampled at on every metric. Always true when sampling isn't
+// configured.
+func (mp *MetricsProvider) Sample(cmd *cobra.Command) bool {
+	if mp.sampler == nil {
+		return true
+	}
+	return mp.sampler.sample(cmd)
+}
+
+// LockLocalAggregate keeps other invocations from touching the on-disk
+// aggregate until unlock is called, which should be after any rollup is
+// exported and cleared so nothing merged in the meantime is lost
+func (mp *MetricsProvider) LockLocalAggregate() (unlock func(), err error) {
+	return lockFile(mp.config.AggregateFile)
+}
+
+// AggregateLocally merges the collected metrics into the on-disk aggregate
+// and returns what should be exported now, which is any metrics that can't
+// be aggregated plus the rollup of the aggregate once it's due. rolledUp
+// is true when the rollup is included, after which ClearLocalAggregate
+// should be called once it's exported.
+func (mp *MetricsProvider) AggregateLocally(collected *metricdata.ResourceMetrics) (export *metricdata.ResourceMetrics, rolledUp bool, err error) {
+	current := now()
+	aggregate, err := loadAggregate(mp.config.AggregateFile, current)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load aggregate: %w", err)
+	}
+
+	export = &metricdata.ResourceMetrics{Resource: collected.Resource}
+	export.ScopeMetrics = aggregate.merge(collected, mp.config.CardinalityLimit)
+
+	size, err := aggregate.save(mp.config.AggregateFile)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to save aggregate: %w", err)
+	}
+
+	if aggregate.isDue(current, mp.config.AggregationWindow, size, mp.config.AggregationMaxSize) {
+		export.ScopeMetrics = append(export.ScopeMetrics, aggregate.rollup(current)...)
+		rolledUp = true
+	}
+	return export, rolledUp, nil
+}
+
+// ClearLocalAggregate removes the on-disk aggregate once it's exported
+func (mp *MetricsProvider) ClearLocalAggregate() error {
+	err := os.Remove(mp.config.AggregateFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
 func (mp *MetricsProvider) Shutdown(ctx context.Context) error {
 	return mp.Provider.Shutdown(ctx)
 }
@@ -112,3 +429,12 @@ func (mp *MetricsProvider) Shutdown(ctx context.Context) error {
 func (mp *MetricsProvider) Config() *Config {
 	return mp.config
 }
+
+// Generates a random ID for tying together the commands run in a session
+func newSessionID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
@@ -3,9 +3,18 @@ package internal
 import (
 	"context"
 	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	metricSdk "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
 )
 
 var mp *MetricsProvider
@@ -66,6 +75,14 @@ func TestNewConfig(t *testing.T) {
 			if config.ServiceName != tt.expectedSvc {
 				t.Errorf("Expected service name %s, got %s", tt.expectedSvc, config.ServiceName)
 			}
+
+			if config.ConsentTimeout != DefaultConsentTimeout {
+				t.Errorf("Expected default consent timeout %v, got %v", DefaultConsentTimeout, config.ConsentTimeout)
+			}
+
+			if config.ConsentMaxRetries != DefaultConsentMaxRetries {
+				t.Errorf("Expected default consent max retries %d, got %d", DefaultConsentMaxRetries, config.ConsentMaxRetries)
+			}
 		})
 	}
 }
@@ -90,6 +107,70 @@ func TestConfigValidate(t *testing.T) {
 			},
 			expectError: true,
 		},
+		{
+			name: "negative consent timeout",
+			config: &Config{
+				ServiceName:    "test-service",
+				ConsentTimeout: -time.Second,
+			},
+			expectError: true,
+		},
+		{
+			name: "unknown consent mode",
+			config: &Config{
+				ServiceName: "test-service",
+				ConsentMode: ConsentMode(42),
+			},
+			expectError: true,
+		},
+		{
+			name: "negative export interval",
+			config: &Config{
+				ServiceName:    "test-service",
+				ExportInterval: -time.Second,
+			},
+			expectError: true,
+		},
+		{
+			name: "unknown temporality",
+			config: &Config{
+				ServiceName: "test-service",
+				Temporality: Temporality("sometimes"),
+			},
+			expectError: true,
+		},
+		{
+			name: "negative cardinality limit",
+			config: &Config{
+				ServiceName:      "test-service",
+				CardinalityLimit: -1,
+			},
+			expectError: true,
+		},
+		{
+			name: "sample rate over 1",
+			config: &Config{
+				ServiceName: "test-service",
+				SampleRate:  1.5,
+			},
+			expectError: true,
+		},
+		{
+			name: "rate limit without window",
+			config: &Config{
+				ServiceName: "test-service",
+				RateLimit:   10,
+			},
+			expectError: true,
+		},
+		{
+			name: "negative consent max retries",
+			config: &Config{
+				ServiceName:       "test-service",
+				ConsentMaxRetries: -1,
+			},
+			expectError: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -209,3 +290,250 @@ func TestConfigValidateMultipleErrors(t *testing.T) {
 		t.Error("Expected service name error")
 	}
 }
+
+func TestNewMetricsProviderTemporalityAndViews(t *testing.T) {
+	ctx := context.Background()
+	provider, err := NewMetricsProvider(ctx, &Config{
+		ServiceName: "test-service",
+		Temporality: TemporalityCumulative,
+		Views: []metricSdk.View{
+			metricSdk.NewView(
+				metricSdk.Instrument{Name: "original"},
+				metricSdk.Stream{Name: "renamed"},
+			),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer provider.Shutdown(ctx)
+
+	counter, err := provider.GetMeter().Int64Counter("original")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	counter.Add(ctx, 1)
+
+	collected := &metricdata.ResourceMetrics{}
+	if err := provider.Reader.Collect(ctx, collected); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(collected.ScopeMetrics) != 1 || len(collected.ScopeMetrics[0].Metrics) != 1 {
+		t.Fatalf("Expected a single metric, got %+v", collected.ScopeMetrics)
+	}
+
+	m := collected.ScopeMetrics[0].Metrics[0]
+	if m.Name != "renamed" {
+		t.Errorf("Expected metric name 'renamed', got %s", m.Name)
+	}
+
+	sum, ok := m.Data.(metricdata.Sum[int64])
+	if !ok {
+		t.Fatalf("Expected Sum[int64], got %T", m.Data)
+	}
+	if sum.Temporality != metricdata.CumulativeTemporality {
+		t.Errorf("Expected cumulative temporality, got %v", sum.Temporality)
+	}
+}
+
+func TestNewMetricsProviderCardinalityLimit(t *testing.T) {
+	ctx := context.Background()
+	provider, err := NewMetricsProvider(ctx, &Config{
+		ServiceName:      "test-service",
+		CardinalityLimit: 2,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer provider.Shutdown(ctx)
+
+	counter, err := provider.GetMeter().Int64Counter("invocations")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, command := range []string{"a", "b", "c"} {
+		counter.Add(ctx, 1, metric.WithAttributes(attribute.String("command", command)))
+	}
+
+	sets := collectAttributes(t, provider.Reader)["invocations"]
+	if len(sets) != 2 {
+		t.Fatalf("Expected 2 series, got %d", len(sets))
+	}
+
+	overflowed := false
+	for _, set := range sets {
+		if set.Equals(&overflowSet) {
+			overflowed = true
+		}
+	}
+	if !overflowed {
+		t.Error("Expected an overflow series")
+	}
+
+	provider.ResetCardinalityLimit()
+	counter.Add(ctx, 1, metric.WithAttributes(attribute.String("command", "c")))
+
+	sets = collectAttributes(t, provider.Reader)["invocations"]
+	if len(sets) != 1 || sets[0].Equals(&overflowSet) {
+		t.Error("Expected the limit to be reset")
+	}
+}
+
+func TestNewMetricsProviderAttributeProcessors(t *testing.T) {
+	ctx := context.Background()
+	provider, err := NewMetricsProvider(ctx, &Config{
+		ServiceName:         "test-service",
+		AttributeProcessors: []AttributeProcessor{DropAttributes("tty"), ScrubAttributes()},
+		HashedAttributes:    []string{"team"},
+		SaltFile:            filepath.Join(t.TempDir(), ".test-service-metrics-salt"),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer provider.Shutdown(ctx)
+
+	counter, err := provider.GetMeter().Int64Counter("invocations")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	counter.Add(ctx, 1, metric.WithAttributes(
+		attribute.Bool("tty", true),
+		attribute.String("owner", "jane@example.com"),
+		attribute.String("team", "platform"),
+	))
+
+	sets := collectAttributes(t, provider.Reader)["invocations"]
+	if len(sets) != 1 {
+		t.Fatalf("Expected 1 series, got %d", len(sets))
+	}
+
+	if sets[0].HasValue("tty") {
+		t.Error("Expected tty to be dropped")
+	}
+	if owner, _ := sets[0].Value("owner"); owner.AsString() != "<email>" {
+		t.Errorf("Expected owner to be scrubbed, got %q", owner.AsString())
+	}
+	if team, _ := sets[0].Value("team"); team.AsString() == "platform" {
+		t.Error("Expected team to be hashed")
+	}
+}
+
+func TestNewMetricsProviderUnreadableSalt(t *testing.T) {
+	ctx := context.Background()
+	provider, err := NewMetricsProvider(ctx, &Config{
+		ServiceName:      "test-service",
+		HashedAttributes: []string{"team"},
+		SaltFile:         t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer provider.Shutdown(ctx)
+
+	counter, err := provider.GetMeter().Int64Counter("invocations")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	counter.Add(ctx, 1, metric.WithAttributes(attribute.String("team", "platform")))
+
+	sets := collectAttributes(t, provider.Reader)["invocations"]
+	if len(sets) != 1 {
+		t.Fatalf("Expected 1 series, got %d", len(sets))
+	}
+	if team, _ := sets[0].Value("team"); team.AsString() == "platform" {
+		t.Error("Expected team to still be hashed")
+	}
+}
+
+func TestNoConfigDirectory(t *testing.T) {
+	original := defaultOptInDirectory
+	defaultOptInDirectory = ""
+	t.Cleanup(func() { defaultOptInDirectory = original })
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	cwd := t.TempDir()
+	if err := os.Chdir(cwd); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	cmd := &cobra.Command{Use: "my-cli"}
+	config, err := NewConfig(cmd,
+		WithConsentInput(strings.NewReader("y\n")),
+		WithConsentOutput(io.Discard),
+		WithHashedAttributes("team"),
+		WithRateLimit(1, time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, filePath := range []string{config.SaltFile, config.RateLimitFile, config.AggregateFile, config.ExitLatencyFile} {
+		if filePath != "" {
+			t.Errorf("Expected no state file, got %q", filePath)
+		}
+	}
+
+	// consent is still asked for, it just isn't kept
+	if err := HandleMetricsOptIn(cmd, config); err != nil || !UserHasOptedInForMetrics {
+		t.Errorf("HandleMetricsOptIn() = %v, opted in %v, want opted in", err, UserHasOptedInForMetrics)
+	}
+
+	ctx := context.Background()
+	provider, err := NewMetricsProvider(ctx, config)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer provider.Shutdown(ctx)
+
+	for i := 0; i < 3; i++ {
+		if !provider.Sample(cmd) {
+			t.Errorf("invocation %d wasn't sampled, want every invocation without a rate limit count", i)
+		}
+	}
+	if err := config.SaveExitLatency("my-cli", time.Second); err != nil {
+		t.Errorf("SaveExitLatency() = %v, want nil", err)
+	}
+
+	entries, err := os.ReadDir(cwd)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, entry := range entries {
+		t.Errorf("Expected nothing written to the working directory, got %s", entry.Name())
+	}
+}
+
+func TestNewMetricsProviderSampling(t *testing.T) {
+	ctx := context.Background()
+	provider, err := NewMetricsProvider(ctx, &Config{
+		ServiceName: "test-service",
+		SampleRate:  0.5,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer provider.Shutdown(ctx)
+
+	cmd := &cobra.Command{Use: "my-cli", Annotations: map[string]string{AnnotationSampleRate: "1"}}
+	if !provider.Sample(cmd) {
+		t.Error("Expected invocation to be sampled")
+	}
+
+	counter, err := provider.GetMeter().Int64Counter("invocations")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	counter.Add(ctx, 1)
+
+	sets := collectAttributes(t, provider.Reader)["invocations"]
+	if len(sets) != 1 {
+		t.Fatalf("Expected 1 series, got %d", len(sets))
+	}
+	if rate, ok := sets[0].Value(AttributeSampleRate); !ok || rate.AsFloat64() != 1 {
+		t.Errorf("Expected sample_rate 1, got %v", rate.Emit())
+	}
+}
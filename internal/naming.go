@@ -0,0 +1,279 @@
+package internal
+
+import (
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// NamingScheme determines how the built-in metrics and their attributes
+// are named
+type NamingScheme int
+
+const (
+	// Metrics are named cli-<root>-<metric> with bare attribute keys,
+	// e.g. cli-my-cli-invocations with command and flag-name attributes
+	NamingSchemeLegacy NamingScheme = iota
+
+	// Metrics and attributes follow the OTel naming guidance, e.g.
+	// cli.command.invocations with cli.name, cli.command.name and
+	// cli.flag.<name> attributes, so dashboards work across CLIs
+	NamingSchemeSemconv
+)
+
+// BuiltinMetric identifies one of the metrics recorded by this package
+type BuiltinMetric string
+
+const (
+	MetricInvocations     BuiltinMetric = "invocations"
+	MetricDeprecatedUsage BuiltinMetric = "deprecated-usage"
+	MetricErrors          BuiltinMetric = "errors"
+	MetricCompletions     BuiltinMetric = "completions"
+	MetricDuration        BuiltinMetric = "duration"
+	MetricUserCPUTime     BuiltinMetric = "user-cpu-time"
+	MetricSystemCPUTime   BuiltinMetric = "system-cpu-time"
+	MetricMaxRSS          BuiltinMetric = "max-rss"
+	MetricGCPauses        BuiltinMetric = "gc-pauses"
+	MetricHeapAllocated   BuiltinMetric = "heap-allocated"
+	MetricGCCycles        BuiltinMetric = "gc-cycles"
+	MetricGoroutines      BuiltinMetric = "goroutines"
+	MetricStartupLatency  BuiltinMetric = "startup-latency"
+	MetricExitLatency     BuiltinMetric = "exit-latency"
+	MetricHTTPRequests    BuiltinMetric = "http-requests"
+	MetricHTTPDuration    BuiltinMetric = "http-duration"
+)
+
+// MetricDefinition is the name, description and unit a metric is
+// recorded with
+type MetricDefinition struct {
+	Name        string
+	Description string
+	Unit        string
+}
+
+// Attribute keys recorded on the built-in metrics, named as they are
+// in NamingSchemeLegacy
+const (
+	AttributeCLIName        = "cli_name"
+	AttributeCommand        = "command"
+	AttributeTTY            = "tty"
+	AttributeInvokedAs      = "invoked_as"
+	AttributeShorthandFlags = "shorthand_flags"
+	AttributeSession        = "session"
+	AttributeKind           = "kind"
+	AttributeFlag           = "flag"
+	AttributeCategory       = "category"
+	AttributeSampleRate     = "sample_rate"
+	AttributeHost           = "host"
+	AttributeMethod         = "method"
+	AttributeStatusClass    = "status_class"
+)
+
+type builtinMetricNames struct {
+	semconv     string
+	description string
+	legacyUnit  string
+	semconvUnit string
+}
+
+var builtinMetrics = map[BuiltinMetric]builtinMetricNames{
+	MetricInvocations: {
+		semconv:     "cli.command.invocations",
+		description: "Command Invocation",
+		legacyUnit:  "1",
+		semconvUnit: "{invocation}",
+	},
+	MetricDeprecatedUsage: {
+		semconv:     "cli.command.deprecated_usage",
+		description: "Deprecated Command and Flag Usage",
+		legacyUnit:  "1",
+		semconvUnit: "{usage}",
+	},
+	MetricErrors: {
+		semconv:     "cli.command.errors",
+		description: "Command Errors Before Run",
+		legacyUnit:  "1",
+		semconvUnit: "{error}",
+	},
+	MetricCompletions: {
+		semconv:     "cli.command.completions",
+		description: "Shell Completion Requests",
+		legacyUnit:  "1",
+		semconvUnit: "{request}",
+	},
+	MetricDuration: {
+		semconv:     "cli.command.duration",
+		description: "Command Wall Time",
+		legacyUnit:  "s",
+		semconvUnit: "s",
+	},
+	MetricUserCPUTime: {
+		semconv:     "cli.command.cpu.user_time",
+		description: "Command User CPU Time",
+		legacyUnit:  "s",
+		semconvUnit: "s",
+	},
+	MetricSystemCPUTime: {
+		semconv:     "cli.command.cpu.system_time",
+		description: "Command System CPU Time",
+		legacyUnit:  "s",
+		semconvUnit: "s",
+	},
+	MetricMaxRSS: {
+		semconv:     "cli.command.memory.max_rss",
+		description: "Process Max Resident Set Size",
+		legacyUnit:  "By",
+		semconvUnit: "By",
+	},
+	MetricGCPauses: {
+		semconv:     "cli.command.runtime.gc.pause_time",
+		description: "Command GC Pause Time",
+		legacyUnit:  "s",
+		semconvUnit: "s",
+	},
+	MetricHeapAllocated: {
+		semconv:     "cli.command.runtime.heap.allocated",
+		description: "Command Heap Allocated",
+		legacyUnit:  "By",
+		semconvUnit: "By",
+	},
+	MetricGCCycles: {
+		semconv:     "cli.command.runtime.gc.cycles",
+		description: "Command GC Cycles",
+		legacyUnit:  "1",
+		semconvUnit: "{cycle}",
+	},
+	MetricGoroutines: {
+		semconv:     "cli.command.runtime.goroutines.max",
+		description: "Command Goroutine High-Water Mark",
+		legacyUnit:  "1",
+		semconvUnit: "{goroutine}",
+	},
+	MetricStartupLatency: {
+		semconv:     "cli.command.startup.duration",
+		description: "Process Start to Command Run",
+		legacyUnit:  "s",
+		semconvUnit: "s",
+	},
+	MetricExitLatency: {
+		semconv:     "cli.command.exit.duration",
+		description: "Command Run Completion to Process Exit",
+		legacyUnit:  "s",
+		semconvUnit: "s",
+	},
+	MetricHTTPRequests: {
+		semconv:     "cli.http.client.requests",
+		description: "Outbound HTTP Requests",
+		legacyUnit:  "1",
+		semconvUnit: "{request}",
+	},
+	MetricHTTPDuration: {
+		semconv:     "cli.http.client.duration",
+		description: "Outbound HTTP Request Duration",
+		legacyUnit:  "s",
+		semconvUnit: "s",
+	},
+}
+
+// Histogram buckets for the built-in histograms, which can be changed
+// with a View
+var (
+	durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 1800, 3600}
+	bytesBuckets    = []float64{1 << 20, 4 << 20, 16 << 20, 32 << 20, 64 << 20, 128 << 20, 256 << 20, 512 << 20, 1 << 30, 2 << 30, 4 << 30, 8 << 30}
+	pauseBuckets    = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 5}
+	countBuckets    = []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 5000, 10000}
+
+	builtinMetricBuckets = map[BuiltinMetric][]float64{
+		MetricDuration:       durationBuckets,
+		MetricUserCPUTime:    durationBuckets,
+		MetricSystemCPUTime:  durationBuckets,
+		MetricMaxRSS:         bytesBuckets,
+		MetricGCPauses:       pauseBuckets,
+		MetricHeapAllocated:  bytesBuckets,
+		MetricGCCycles:       countBuckets,
+		MetricGoroutines:     countBuckets,
+		MetricStartupLatency: durationBuckets,
+		MetricExitLatency:    durationBuckets,
+		MetricHTTPDuration:   durationBuckets,
+	}
+)
+
+// HistogramBuckets returns the bucket boundaries for a built-in histogram
+func HistogramBuckets(m BuiltinMetric) []float64 {
+	return builtinMetricBuckets[m]
+}
+
+var semconvAttributeKeys = map[string]string{
+	AttributeCLIName:        "cli.name",
+	AttributeCommand:        "cli.command.name",
+	AttributeTTY:            "cli.tty",
+	AttributeInvokedAs:      "cli.command.invoked_as",
+	AttributeShorthandFlags: "cli.command.shorthand_flags",
+	AttributeSession:        "cli.session.id",
+	AttributeKind:           "cli.deprecation.kind",
+	AttributeFlag:           "cli.deprecation.flag",
+	AttributeCategory:       "error.type",
+	AttributeSampleRate:     "cli.sample_rate",
+	AttributeHost:           "server.address",
+	AttributeMethod:         "http.request.method",
+	AttributeStatusClass:    "http.response.status_class",
+}
+
+// MetricDefinition returns the name, description and unit the built-in
+// metric is recorded with for the command's CLI, applying any overrides
+func (c *Config) MetricDefinition(cmd *cobra.Command, m BuiltinMetric) MetricDefinition {
+	names := builtinMetrics[m]
+	definition := MetricDefinition{
+		Name:        "cli-" + GetRootCmdName(cmd) + "-" + string(m),
+		Description: names.description,
+		Unit:        names.legacyUnit,
+	}
+	if c.NamingScheme == NamingSchemeSemconv {
+		definition.Name = names.semconv
+		definition.Unit = names.semconvUnit
+	}
+
+	override := c.MetricOverrides[m]
+	if override.Name != "" {
+		definition.Name = override.Name
+	}
+	if override.Description != "" {
+		definition.Description = override.Description
+	}
+	if override.Unit != "" {
+		definition.Unit = override.Unit
+	}
+	return definition
+}
+
+// AttributeKey returns the key a built-in attribute is recorded under
+func (c *Config) AttributeKey(key string) attribute.Key {
+	if c.NamingScheme == NamingSchemeSemconv {
+		if semconvKey, ok := semconvAttributeKeys[key]; ok {
+			return attribute.Key(semconvKey)
+		}
+	}
+	return attribute.Key(key)
+}
+
+// FlagAttributes returns the flags passed to the command as attributes,
+// keyed by the flag name or cli.flag.<name>
+func (c *Config) FlagAttributes(cmd *cobra.Command) []attribute.KeyValue {
+	flags := ParseCmdFlagsToAttributes(cmd)
+	if c.NamingScheme == NamingSchemeSemconv {
+		for i, flag := range flags {
+			flags[i].Key = attribute.Key("cli.flag." + string(flag.Key))
+		}
+	}
+	return flags
+}
+
+// CommonAttributes returns the attributes recorded on every built-in
+// metric. Legacy names embed the CLI name in the metric name instead.
+func (c *Config) CommonAttributes(cmd *cobra.Command) []attribute.KeyValue {
+	if c.NamingScheme != NamingSchemeSemconv {
+		return nil
+	}
+	return []attribute.KeyValue{
+		c.AttributeKey(AttributeCLIName).String(GetRootCmdName(cmd)),
+	}
+}
@@ -0,0 +1,199 @@
+package internal
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestMetricDefinition(t *testing.T) {
+	root := &cobra.Command{Use: "my-cli"}
+
+	tests := []struct {
+		name     string
+		config   *Config
+		metric   BuiltinMetric
+		expected MetricDefinition
+	}{
+		{
+			name:     "legacy invocations",
+			config:   &Config{},
+			metric:   MetricInvocations,
+			expected: MetricDefinition{Name: "cli-my-cli-invocations", Description: "Command Invocation", Unit: "1"},
+		},
+		{
+			name:     "legacy errors",
+			config:   &Config{},
+			metric:   MetricErrors,
+			expected: MetricDefinition{Name: "cli-my-cli-errors", Description: "Command Errors Before Run", Unit: "1"},
+		},
+		{
+			name:     "semconv invocations",
+			config:   &Config{NamingScheme: NamingSchemeSemconv},
+			metric:   MetricInvocations,
+			expected: MetricDefinition{Name: "cli.command.invocations", Description: "Command Invocation", Unit: "{invocation}"},
+		},
+		{
+			name:     "semconv deprecated usage",
+			config:   &Config{NamingScheme: NamingSchemeSemconv},
+			metric:   MetricDeprecatedUsage,
+			expected: MetricDefinition{Name: "cli.command.deprecated_usage", Description: "Deprecated Command and Flag Usage", Unit: "{usage}"},
+		},
+		{
+			name:     "legacy max rss",
+			config:   &Config{},
+			metric:   MetricMaxRSS,
+			expected: MetricDefinition{Name: "cli-my-cli-max-rss", Description: "Process Max Resident Set Size", Unit: "By"},
+		},
+		{
+			name:     "semconv duration",
+			config:   &Config{NamingScheme: NamingSchemeSemconv},
+			metric:   MetricDuration,
+			expected: MetricDefinition{Name: "cli.command.duration", Description: "Command Wall Time", Unit: "s"},
+		},
+		{
+			name: "partial override",
+			config: &Config{
+				NamingScheme: NamingSchemeSemconv,
+				MetricOverrides: map[BuiltinMetric]MetricDefinition{
+					MetricInvocations: {Name: "acme.cli.runs"},
+				},
+			},
+			metric:   MetricInvocations,
+			expected: MetricDefinition{Name: "acme.cli.runs", Description: "Command Invocation", Unit: "{invocation}"},
+		},
+		{
+			name: "full override",
+			config: &Config{
+				MetricOverrides: map[BuiltinMetric]MetricDefinition{
+					MetricCompletions: {Name: "tabs", Description: "TABs", Unit: "{tab}"},
+				},
+			},
+			metric:   MetricCompletions,
+			expected: MetricDefinition{Name: "tabs", Description: "TABs", Unit: "{tab}"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.MetricDefinition(root, tt.metric); got != tt.expected {
+				t.Errorf("MetricDefinition() = %+v, want %+v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	tests := []struct {
+		metric   BuiltinMetric
+		expected []float64
+	}{
+		{metric: MetricDuration, expected: durationBuckets},
+		{metric: MetricUserCPUTime, expected: durationBuckets},
+		{metric: MetricMaxRSS, expected: bytesBuckets},
+		{metric: MetricGCPauses, expected: pauseBuckets},
+		{metric: MetricGoroutines, expected: countBuckets},
+		{metric: MetricInvocations, expected: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.metric), func(t *testing.T) {
+			if got := HistogramBuckets(tt.metric); !slices.Equal(got, tt.expected) {
+				t.Errorf("HistogramBuckets() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAttributeKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		scheme   NamingScheme
+		key      string
+		expected attribute.Key
+	}{
+		{name: "legacy command", scheme: NamingSchemeLegacy, key: AttributeCommand, expected: "command"},
+		{name: "semconv command", scheme: NamingSchemeSemconv, key: AttributeCommand, expected: "cli.command.name"},
+		{name: "semconv cli name", scheme: NamingSchemeSemconv, key: AttributeCLIName, expected: "cli.name"},
+		{name: "semconv category", scheme: NamingSchemeSemconv, key: AttributeCategory, expected: "error.type"},
+		{name: "semconv shorthand flags", scheme: NamingSchemeSemconv, key: AttributeShorthandFlags, expected: "cli.command.shorthand_flags"},
+		{name: "semconv deprecated flag", scheme: NamingSchemeSemconv, key: AttributeFlag, expected: "cli.deprecation.flag"},
+		{name: "semconv unknown key", scheme: NamingSchemeSemconv, key: "team", expected: "team"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{NamingScheme: tt.scheme}
+			if got := config.AttributeKey(tt.key); got != tt.expected {
+				t.Errorf("AttributeKey(%q) = %q, want %q", tt.key, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSemconvAttributeKeysAvoidFlagNamespace(t *testing.T) {
+	// cli.flag.<name> holds the flags passed, so a built-in key in it
+	// would collide with a flag of the same name
+	for key, semconvKey := range semconvAttributeKeys {
+		if strings.HasPrefix(semconvKey, "cli.flag.") {
+			t.Errorf("semconv key for %q is %q, which can collide with a flag attribute", key, semconvKey)
+		}
+	}
+}
+
+func TestSemconvAttributeKeysAreNotNamespaces(t *testing.T) {
+	// A key can't be both an attribute and the namespace of another, e.g.
+	// cli.command alongside cli.command.invoked_as
+	for key, semconvKey := range semconvAttributeKeys {
+		for other, otherSemconvKey := range semconvAttributeKeys {
+			if strings.HasPrefix(otherSemconvKey, semconvKey+".") {
+				t.Errorf("semconv key for %q is %q, which is the namespace of %q for %q", key, semconvKey, otherSemconvKey, other)
+			}
+		}
+	}
+}
+
+func TestFlagAndCommonAttributes(t *testing.T) {
+	root := &cobra.Command{Use: "my-cli"}
+	root.Flags().Bool("verbose", false, "")
+	root.Flags().Parse([]string{"--verbose"})
+
+	legacy := &Config{}
+	if got := legacy.FlagAttributes(root); len(got) != 1 || got[0].Key != "verbose" {
+		t.Errorf("FlagAttributes() = %v, want [verbose]", got)
+	}
+	if got := legacy.CommonAttributes(root); len(got) != 0 {
+		t.Errorf("CommonAttributes() = %v, want none", got)
+	}
+
+	semconv := &Config{NamingScheme: NamingSchemeSemconv}
+	if got := semconv.FlagAttributes(root); len(got) != 1 || got[0].Key != "cli.flag.verbose" {
+		t.Errorf("FlagAttributes() = %v, want [cli.flag.verbose]", got)
+	}
+	common := semconv.CommonAttributes(root)
+	if len(common) != 1 || common[0] != attribute.String("cli.name", "my-cli") {
+		t.Errorf("CommonAttributes() = %v, want [cli.name=my-cli]", common)
+	}
+}
+
+func TestConfigValidateNaming(t *testing.T) {
+	config := &Config{
+		ServiceName:  "test-service",
+		NamingScheme: NamingScheme(42),
+		MetricOverrides: map[BuiltinMetric]MetricDefinition{
+			BuiltinMetric("bogus"): {Name: "bogus"},
+		},
+	}
+
+	err := config.validate()
+	if !errors.Is(err, ErrNamingSchemeUnknown) {
+		t.Errorf("Expected naming scheme error, got %v", err)
+	}
+	if !errors.Is(err, ErrBuiltinMetricUnknown) {
+		t.Errorf("Expected built-in metric error, got %v", err)
+	}
+}
@@ -1,27 +1,49 @@
 package internal
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/mattn/go-isatty"
 	"github.com/spf13/cobra"
 )
 
+// ConsentMode determines how consent is decided for sessions that
+// can't be prompted
+type ConsentMode int
+
+const (
+	// Non-interactive sessions are opted in unless an opt-out file exists
+	ConsentModeDefault ConsentMode = iota
+
+	// Non-interactive sessions are opted out unless they explicitly opt in
+	// with an opt-in file, environment variable or flag
+	ConsentModeStrict
+)
+
 // global vars
 var (
 	// Globally accessible var to determine if the user has opted in or not
 	// for metric collection.
 	UserHasOptedInForMetrics bool
 
-	// TODO - test this works on linux and when $HOME is not set or the path in XDG_CONFIG_HOME is not relative
+	// Where the opt-in and every other state file is kept. Empty when the
+	// user has no config directory, e.g. $HOME isn't set, in which case
+	// none of them are kept rather than writing them to the working
+	// directory.
 	defaultOptInDirectory        = func() string { dir, _ := os.UserConfigDir(); return dir }()
 	defaultOptInFilenamePostfix  = "metrics-optin"
 	defaultOptOutFilenamePostfix = "metrics-optout"
+	defaultOptInEnvVarPostfix    = "_METRICS_OPTIN"
 )
 
 var defaultConsentPrompt = `
@@ -44,22 +66,65 @@ the developers of this tool? (y|N) `
 var defaultOptInMessage = "\nThank you for sharing!"
 var defaultOptOutMessage = "\nYou have opted out. We will not collect metrics."
 var defaultConsentRetryMessage = "\nInvalid value detected. Only Y and N are allowed..."
+var defaultConsentGiveUpMessage = "\nNo valid answer was given. We will not collect metrics and will ask again next time."
+var defaultConsentTimeoutMessage = "\nNo answer was given in time. We will not collect metrics and will ask again next time."
+
+var errConsentTimeout = errors.New("timed out waiting for consent")
+
+// consentPrompter asks the user for consent on the configured input and
+// output, falling back to the command's own streams.
+type consentPrompter struct {
+	in         *consentReader
+	out        io.Writer
+	timeout    time.Duration
+	maxRetries int
+}
 
-func HandleMetricsOptIn(cmd *cobra.Command) error {
-	// By default, if we have a non-interactive session let's automatically
-	// collect metrics.
+func newConsentPrompter(cmd *cobra.Command, config *Config) *consentPrompter {
+	var in io.Reader = cmd.InOrStdin()
+	if config.ConsentInput != nil {
+		in = config.ConsentInput
+	}
+	var out io.Writer = cmd.ErrOrStderr()
+	if config.ConsentOutput != nil {
+		out = config.ConsentOutput
+	}
+	return &consentPrompter{
+		in:         newConsentReader(in),
+		out:        out,
+		timeout:    config.ConsentTimeout,
+		maxRetries: config.ConsentMaxRetries,
+	}
+}
+
+// Determines if the user can be prompted for consent. Files, including
+// one set with WithConsentInput, have to be a terminal, while any other
+// input set with WithConsentInput is always prompted on.
+func isConsentInteractive(cmd *cobra.Command, config *Config) bool {
+	in := config.ConsentInput
+	if in == nil {
+		in = cmd.InOrStdin()
+	}
+	if file, ok := in.(*os.File); ok {
+		return isatty.IsTerminal(file.Fd())
+	}
+	return config.ConsentInput != nil
+}
+
+func HandleMetricsOptIn(cmd *cobra.Command, config *Config) error {
 	rootCmdName := GetRootCmdName(cmd)
-	if !IsTTY() {
-		handleDefaultInteractiveOptOut(getDefaultOptOutFilePath(rootCmdName))
+	if !isConsentInteractive(cmd, config) {
+		handleNonInteractiveOptIn(cmd, config)
 		return nil
 	}
 
+	prompter := newConsentPrompter(cmd, config)
 	filePath := getDefaultOptInFilePath(rootCmdName)
 	file, err := os.Open(filePath)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
-			printConsentPrompt()
-			userConsent, promptErr := askForConsentAndSave(filePath)
+			prompter.printConsentPrompt()
+			userConsent, promptErr := prompter.askForConsentAndSave(filePath)
 			if promptErr != nil {
 				UserHasOptedInForMetrics = false
 				return fmt.Errorf("Error prompting for metric collection consent. Metrics will not be collected. %w", promptErr)
@@ -85,8 +150,8 @@ func HandleMetricsOptIn(cmd *cobra.Command) error {
 	}
 	// If we get here, we can assume that the file is corrupted or has unknown values.
 	// So let's ask the user to opt-in again.
-	printConsentPrompt()
-	userConsent, promptErr := askForConsentAndSave(filePath)
+	prompter.printConsentPrompt()
+	userConsent, promptErr := prompter.askForConsentAndSave(filePath)
 	if promptErr != nil {
 		UserHasOptedInForMetrics = false
 		return fmt.Errorf("Error prompting for metric collection consent. Metrics will not be collected. %w", promptErr)
@@ -95,6 +160,64 @@ func HandleMetricsOptIn(cmd *cobra.Command) error {
 	return nil
 }
 
+// Decides if metrics are collected without ever prompting, for
+// invocations that fail before the consent prompt would be shown.
+// Interactive sessions only count as opted in if they have previously
+// opted in with the prompt.
+func HandleMetricsOptInWithoutPrompt(cmd *cobra.Command, config *Config) {
+	if !isConsentInteractive(cmd, config) {
+		handleNonInteractiveOptIn(cmd, config)
+		return
+	}
+
+	UserHasOptedInForMetrics = hasOptInFile(GetRootCmdName(cmd))
+}
+
+// Non-interactive sessions can't be prompted. By default they are opted in
+// unless they have opted out, but in strict mode they are opted out unless
+// they have explicitly opted in.
+func handleNonInteractiveOptIn(cmd *cobra.Command, config *Config) {
+	rootCmdName := GetRootCmdName(cmd)
+	if config.ConsentMode != ConsentModeStrict {
+		handleDefaultInteractiveOptOut(getDefaultOptOutFilePath(rootCmdName))
+		return
+	}
+
+	if _, err := os.Stat(getDefaultOptOutFilePath(rootCmdName)); err == nil {
+		UserHasOptedInForMetrics = false
+		return
+	}
+	UserHasOptedInForMetrics = hasExplicitOptIn(cmd, config)
+}
+
+// Checks the opt-in file, environment variable and flag for an explicit
+// opt-in. Anything that can't be read or parsed counts as not opted in.
+func hasExplicitOptIn(cmd *cobra.Command, config *Config) bool {
+	if hasOptInFile(GetRootCmdName(cmd)) {
+		return true
+	}
+
+	if config.OptInEnvVar != "" {
+		if envOptIn, err := strconv.ParseBool(os.Getenv(config.OptInEnvVar)); err == nil && envOptIn {
+			return true
+		}
+	}
+
+	if config.OptInFlag != "" {
+		if flagOptIn, err := cmd.Flags().GetBool(config.OptInFlag); err == nil && flagOptIn {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Determines if the opt-in file exists and records an opt-in
+func hasOptInFile(rootCmdName string) bool {
+	optIn, err := os.ReadFile(getDefaultOptInFilePath(rootCmdName))
+	return err == nil && len(optIn) > 0 && optIn[0] == '1'
+}
+
 func handleDefaultInteractiveOptOut(filePath string) {
 	_, err := os.Open(filePath)
 	if errors.Is(err, fs.ErrNotExist) {
@@ -104,36 +227,171 @@ func handleDefaultInteractiveOptOut(filePath string) {
 	UserHasOptedInForMetrics = false
 }
 
-func printConsentPrompt() {
-	fmt.Fprint(os.Stderr, defaultConsentPrompt)
+func (p *consentPrompter) printConsentPrompt() {
+	fmt.Fprint(p.out, defaultConsentPrompt)
+}
+
+// askForConsentAndSave asks the consent question until a valid answer is
+// given or the retries run out. Running out of retries or time counts as
+// "no" but is not saved, so the user will be asked again next time.
+func (p *consentPrompter) askForConsentAndSave(filePath string) (bool, error) {
+	for attempt := 0; attempt <= p.maxRetries; attempt++ {
+		fmt.Fprint(p.out, defaultConsentQuestion)
+		message, err := p.readAnswer()
+		if errors.Is(err, errConsentTimeout) {
+			fmt.Fprintln(p.out, defaultConsentTimeoutMessage)
+			return false, nil
+		}
+
+		optInStatus, ok := parseConsentAnswer(message)
+		if !ok {
+			fmt.Fprintln(p.out, defaultConsentRetryMessage)
+			continue
+		}
+
+		if optInStatus {
+			fmt.Fprintln(p.out, defaultOptInMessage)
+		} else {
+			fmt.Fprintln(p.out, defaultOptOutMessage)
+		}
+
+		// Without anywhere to keep it, the user is asked every time
+		if filePath == "" {
+			return optInStatus, nil
+		}
+		err = saveOptInStatus(filePath, optInStatus)
+		if err != nil {
+			return optInStatus, fmt.Errorf("Unable to save opt-in status. User will be asked again for consent for telemetry. %w", err)
+		}
+		return optInStatus, nil
+	}
+
+	fmt.Fprintln(p.out, defaultConsentGiveUpMessage)
+	return false, nil
+}
+
+// readAnswer reads a single line from the input, giving up once the
+// timeout has passed
+func (p *consentPrompter) readAnswer() (string, error) {
+	return p.in.readLine(p.timeout)
+}
+
+// consentReader reads answers to the consent prompt. Only the answer's
+// line is read, a byte at a time, so everything after it is left for the
+// command to read.
+type consentReader struct {
+	in io.Reader
+
+	// The input's file descriptor if it's a file, which is polled so a
+	// timed out prompt never leaves a read behind to take the user's
+	// next line. Anything else is read in the background, and a read
+	// left behind by a timed out prompt is picked up by the next one.
+	fd int
+}
+
+var (
+	pendingConsentReadsMu sync.Mutex
+	pendingConsentReads   = map[io.Reader]chan string{}
+)
+
+func newConsentReader(in io.Reader) *consentReader {
+	fd := -1
+	if file, ok := in.(*os.File); ok {
+		fd = int(file.Fd())
+	}
+	return &consentReader{in: in, fd: fd}
+}
+
+func (r *consentReader) readLine(timeout time.Duration) (string, error) {
+	pending := r.takePendingRead()
+	if pending == nil {
+		if timeout <= 0 {
+			return readLine(r.in), nil
+		}
+
+		if ready, ok := waitForInput(r.fd, timeout); ok {
+			if !ready {
+				return "", errConsentTimeout
+			}
+			return readLine(r.in), nil
+		}
+
+		pending = make(chan string, 1)
+		go func() {
+			pending <- readLine(r.in)
+		}()
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		expired = time.After(timeout)
+	}
+	select {
+	case message := <-pending:
+		return message, nil
+	case <-expired:
+		r.leavePendingRead(pending)
+		return "", errConsentTimeout
+	}
+}
+
+// Takes the read left behind by a timed out prompt on the same input
+func (r *consentReader) takePendingRead() chan string {
+	pendingConsentReadsMu.Lock()
+	defer pendingConsentReadsMu.Unlock()
+
+	if !reflect.TypeOf(r.in).Comparable() {
+		return nil
+	}
+	pending := pendingConsentReads[r.in]
+	delete(pendingConsentReads, r.in)
+	return pending
+}
+
+func (r *consentReader) leavePendingRead(pending chan string) {
+	pendingConsentReadsMu.Lock()
+	defer pendingConsentReadsMu.Unlock()
+
+	if reflect.TypeOf(r.in).Comparable() {
+		pendingConsentReads[r.in] = pending
+	}
 }
 
-func askForConsentAndSave(filePath string) (bool, error) {
-	fmt.Fprint(os.Stderr, defaultConsentQuestion)
-	reader := bufio.NewReader(os.Stdin)
-	message, _ := reader.ReadString('\n')
+// Reads up to and including the next newline, without reading past it
+func readLine(in io.Reader) string {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := in.Read(b)
+		if n > 0 {
+			line = append(line, b[0])
+			if b[0] == '\n' {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	return string(line)
+}
+
+// parseConsentAnswer returns the consent status for an answer and whether
+// the answer was valid. An empty answer takes the default of "no".
+func parseConsentAnswer(message string) (bool, bool) {
+	message = strings.ToLower(strings.TrimSpace(message))
 	if message == "" {
-		message = "n"
+		return false, true
 	}
 
-	var optInStatus bool
-	switch strings.ToLower(message)[0] {
+	switch message[0] {
 	case 'n':
-		optInStatus = false
-		fmt.Fprintln(os.Stderr, defaultOptOutMessage)
+		return false, true
 	case 'y':
-		optInStatus = true
-		fmt.Fprintln(os.Stderr, defaultOptInMessage)
+		return true, true
 	default:
-		fmt.Fprintln(os.Stderr, defaultConsentRetryMessage)
-		return askForConsentAndSave(filePath)
-	}
-
-	err := saveOptInStatus(filePath, optInStatus)
-	if err != nil {
-		return optInStatus, fmt.Errorf("Unable to save opt-in status. User will be asked again for consent for telemetry. %w", err)
+		return false, false
 	}
-	return optInStatus, nil
 }
 
 func saveOptInStatus(filePath string, optInStatus bool) error {
@@ -146,7 +404,11 @@ func saveOptInStatus(filePath string, optInStatus bool) error {
 	return err
 }
 
+// Returns an empty path when there's no directory to keep the file in
 func getDefaultConsentFilePath(cmdName string, postfix string) string {
+	if defaultOptInDirectory == "" {
+		return ""
+	}
 	optInFilename := "." + cmdName + "-" + postfix
 	optInFilepath := filepath.Join(defaultOptInDirectory, optInFilename)
 	return optInFilepath
@@ -159,3 +421,15 @@ func getDefaultOptInFilePath(cmdName string) string {
 func getDefaultOptOutFilePath(cmdName string) string {
 	return getDefaultConsentFilePath(cmdName, defaultOptOutFilenamePostfix)
 }
+
+// Builds the default opt-in environment variable from the root command
+// name, e.g. "my-cli" becomes "MY_CLI_METRICS_OPTIN"
+func getDefaultOptInEnvVar(cmdName string) string {
+	envVar := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, cmdName)
+	return strings.ToUpper(envVar) + defaultOptInEnvVarPostfix
+}
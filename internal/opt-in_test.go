@@ -0,0 +1,340 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func TestAskForConsentAndSave(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		maxRetries    int
+		expected      bool
+		expectedSaved string
+	}{
+		{
+			name:          "yes",
+			input:         "y\n",
+			expected:      true,
+			expectedSaved: "1",
+		},
+		{
+			name:          "no",
+			input:         "N\n",
+			expected:      false,
+			expectedSaved: "0",
+		},
+		{
+			name:          "empty answer defaults to no",
+			input:         "\n",
+			expected:      false,
+			expectedSaved: "0",
+		},
+		{
+			name:          "valid answer after retry",
+			input:         "maybe\nyes\n",
+			maxRetries:    1,
+			expected:      true,
+			expectedSaved: "1",
+		},
+		{
+			name:       "retries exhausted",
+			input:      "maybe\nmaybe\nyes\n",
+			maxRetries: 1,
+			expected:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "optin")
+			out := &bytes.Buffer{}
+			prompter := &consentPrompter{
+				in:         newTestReader(tt.input),
+				out:        out,
+				maxRetries: tt.maxRetries,
+			}
+
+			result, err := prompter.askForConsentAndSave(filePath)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if result != tt.expected {
+				t.Errorf("askForConsentAndSave() = %v, want %v", result, tt.expected)
+			}
+
+			saved, err := os.ReadFile(filePath)
+			if tt.expectedSaved == "" {
+				if !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("Expected opt-in file not to be saved, got %q", saved)
+				}
+				return
+			}
+			if string(saved) != tt.expectedSaved {
+				t.Errorf("Expected saved opt-in status %q, got %q", tt.expectedSaved, saved)
+			}
+		})
+	}
+}
+
+func TestAskForConsentAndSaveTimeout(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "optin")
+	in, _ := io.Pipe()
+	out := &bytes.Buffer{}
+	prompter := &consentPrompter{
+		in:      newConsentReader(in),
+		out:     out,
+		timeout: 10 * time.Millisecond,
+	}
+
+	result, err := prompter.askForConsentAndSave(filePath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if result {
+		t.Error("Expected timeout to default to no consent")
+	}
+
+	if _, err := os.Stat(filePath); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("Expected opt-in file not to be saved after a timeout")
+	}
+
+	if !strings.Contains(out.String(), defaultConsentTimeoutMessage) {
+		t.Error("Expected timeout message to be written to the output")
+	}
+}
+
+func TestConsentReaderTimeoutLeavesFileInput(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	reader := newConsentReader(r)
+	if _, err := reader.readLine(10 * time.Millisecond); !errors.Is(err, errConsentTimeout) {
+		t.Fatalf("readLine() error = %v, want %v", err, errConsentTimeout)
+	}
+
+	// A REPL reading the input next gets the line, not an abandoned read
+	w.Write([]byte("next command\n"))
+	buf := make([]byte, 64)
+	n, err := r.Read(buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := string(buf[:n]); got != "next command\n" {
+		t.Errorf("Read() = %q after a timed out prompt, want %q", got, "next command\n")
+	}
+}
+
+func TestConsentReaderTimeoutPendingRead(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+
+	if _, err := newConsentReader(r).readLine(10 * time.Millisecond); !errors.Is(err, errConsentTimeout) {
+		t.Fatalf("readLine() error = %v, want %v", err, errConsentTimeout)
+	}
+
+	// the next prompt on the same input picks up the read left behind
+	go w.Write([]byte("y\n"))
+	message, err := newConsentReader(r).readLine(time.Second)
+	if err != nil || message != "y\n" {
+		t.Errorf("readLine() = %q, %v, want %q, nil", message, err, "y\n")
+	}
+}
+
+func TestConsentReaderLeavesTheRestOfTheInput(t *testing.T) {
+	in := strings.NewReader("y\nthe command's input\n")
+
+	message, err := newConsentReader(in).readLine(0)
+	if err != nil || message != "y\n" {
+		t.Fatalf("readLine() = %q, %v, want %q, nil", message, err, "y\n")
+	}
+	rest, _ := io.ReadAll(in)
+	if string(rest) != "the command's input\n" {
+		t.Errorf("Expected the rest of the input to be left for the command, got %q", rest)
+	}
+}
+
+func TestIsConsentInteractive(t *testing.T) {
+	cmd := &cobra.Command{Use: "myapp"}
+	cmd.SetIn(strings.NewReader("y\n"))
+
+	if isConsentInteractive(cmd, &Config{}) {
+		t.Error("Expected a command input that isn't a terminal not to be prompted")
+	}
+
+	if !isConsentInteractive(cmd, &Config{ConsentInput: strings.NewReader("y\n")}) {
+		t.Error("Expected a configured consent input to be prompted")
+	}
+
+	// a file, like piped stdin, still has to be a terminal
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer r.Close()
+	defer w.Close()
+	if isConsentInteractive(cmd, &Config{ConsentInput: r}) {
+		t.Error("Expected a configured consent input that isn't a terminal not to be prompted")
+	}
+}
+
+func TestNewConsentPrompterDefaults(t *testing.T) {
+	cmd := &cobra.Command{Use: "myapp"}
+	in := strings.NewReader("y\n")
+	errOut := &bytes.Buffer{}
+	cmd.SetIn(in)
+	cmd.SetErr(errOut)
+
+	prompter := newConsentPrompter(cmd, &Config{})
+	prompter.printConsentPrompt()
+
+	if errOut.String() != defaultConsentPrompt {
+		t.Error("Expected consent prompt to be written to the command's stderr")
+	}
+
+	configOut := &bytes.Buffer{}
+	prompter = newConsentPrompter(cmd, &Config{ConsentOutput: configOut})
+	prompter.printConsentPrompt()
+
+	if configOut.String() != defaultConsentPrompt {
+		t.Error("Expected consent prompt to be written to the configured output")
+	}
+}
+
+func useTempOptInDirectory(t *testing.T) string {
+	t.Helper()
+	original := defaultOptInDirectory
+	defaultOptInDirectory = t.TempDir()
+	t.Cleanup(func() { defaultOptInDirectory = original })
+	return defaultOptInDirectory
+}
+
+func TestHandleNonInteractiveOptIn(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     ConsentMode
+		optOut   bool
+		optIn    bool
+		env      string
+		flag     bool
+		expected bool
+	}{
+		{
+			name:     "default mode opts in",
+			mode:     ConsentModeDefault,
+			expected: true,
+		},
+		{
+			name:     "default mode with opt-out file",
+			mode:     ConsentModeDefault,
+			optOut:   true,
+			expected: false,
+		},
+		{
+			name:     "strict mode opts out",
+			mode:     ConsentModeStrict,
+			expected: false,
+		},
+		{
+			name:     "strict mode with opt-in file",
+			mode:     ConsentModeStrict,
+			optIn:    true,
+			expected: true,
+		},
+		{
+			name:     "strict mode with opt-in env var",
+			mode:     ConsentModeStrict,
+			env:      "true",
+			expected: true,
+		},
+		{
+			name:     "strict mode with false opt-in env var",
+			mode:     ConsentModeStrict,
+			env:      "0",
+			expected: false,
+		},
+		{
+			name:     "strict mode with opt-in flag",
+			mode:     ConsentModeStrict,
+			flag:     true,
+			expected: true,
+		},
+		{
+			name:     "strict mode opt-out file wins over opt-in",
+			mode:     ConsentModeStrict,
+			optOut:   true,
+			env:      "1",
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTempOptInDirectory(t)
+			t.Setenv("MYAPP_METRICS_OPTIN", tt.env)
+
+			cmd := &cobra.Command{Use: "myapp", Run: func(cmd *cobra.Command, args []string) {}}
+			cmd.Flags().Bool("metrics", false, "opt in to metrics")
+			if tt.flag {
+				cmd.SetArgs([]string{"--metrics"})
+			} else {
+				cmd.SetArgs([]string{})
+			}
+			cmd.Execute()
+
+			if tt.optOut {
+				os.WriteFile(getDefaultOptOutFilePath("myapp"), []byte{}, 0666)
+			}
+			if tt.optIn {
+				saveOptInStatus(getDefaultOptInFilePath("myapp"), true)
+			}
+
+			config := &Config{
+				ConsentMode: tt.mode,
+				OptInEnvVar: getDefaultOptInEnvVar("myapp"),
+				OptInFlag:   "metrics",
+			}
+			handleNonInteractiveOptIn(cmd, config)
+
+			if UserHasOptedInForMetrics != tt.expected {
+				t.Errorf("UserHasOptedInForMetrics = %v, want %v", UserHasOptedInForMetrics, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGetDefaultOptInEnvVar(t *testing.T) {
+	tests := []struct {
+		cmdName  string
+		expected string
+	}{
+		{cmdName: "myapp", expected: "MYAPP_METRICS_OPTIN"},
+		{cmdName: "my-cli", expected: "MY_CLI_METRICS_OPTIN"},
+		{cmdName: "my.cli2", expected: "MY_CLI2_METRICS_OPTIN"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cmdName, func(t *testing.T) {
+			result := getDefaultOptInEnvVar(tt.cmdName)
+			if result != tt.expected {
+				t.Errorf("getDefaultOptInEnvVar() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
@@ -1,6 +1,12 @@
 package internal
 
-import metricSdk "go.opentelemetry.io/otel/sdk/metric"
+import (
+	"fmt"
+	"io"
+	"time"
+
+	metricSdk "go.opentelemetry.io/otel/sdk/metric"
+)
 
 type Option interface {
 	applier
@@ -35,3 +41,299 @@ func WithServiceName(name string) Option {
 		return nil
 	})
 }
+
+// WithConsentInput sets where the consent prompt reads the user's answer from
+func WithConsentInput(r io.Reader) Option {
+	return option(func(cfg *Config) error {
+		cfg.ConsentInput = r
+		return nil
+	})
+}
+
+// WithConsentOutput sets where the consent prompt is written to
+func WithConsentOutput(w io.Writer) Option {
+	return option(func(cfg *Config) error {
+		cfg.ConsentOutput = w
+		return nil
+	})
+}
+
+// WithConsentTimeout sets how long to wait for an answer to the consent
+// prompt before defaulting to "no". A timeout of zero waits forever.
+func WithConsentTimeout(timeout time.Duration) Option {
+	return option(func(cfg *Config) error {
+		cfg.ConsentTimeout = timeout
+		return nil
+	})
+}
+
+// WithConsentMaxRetries sets how many times an invalid answer to the
+// consent prompt is re-asked before defaulting to "no"
+func WithConsentMaxRetries(retries int) Option {
+	return option(func(cfg *Config) error {
+		cfg.ConsentMaxRetries = retries
+		return nil
+	})
+}
+
+// WithConsentMode sets how consent is decided for non-interactive sessions
+func WithConsentMode(mode ConsentMode) Option {
+	return option(func(cfg *Config) error {
+		cfg.ConsentMode = mode
+		return nil
+	})
+}
+
+// WithOptInEnvVar sets the environment variable that explicitly opts a
+// session in when running in ConsentModeStrict
+func WithOptInEnvVar(name string) Option {
+	return option(func(cfg *Config) error {
+		cfg.OptInEnvVar = name
+		return nil
+	})
+}
+
+// WithOptInFlag sets the name of a boolean flag that explicitly opts a
+// session in when running in ConsentModeStrict. The flag itself must be
+// defined on the command tree by the caller.
+func WithOptInFlag(name string) Option {
+	return option(func(cfg *Config) error {
+		cfg.OptInFlag = name
+		return nil
+	})
+}
+
+// WithNoTelemetryFlag adds a persistent flag to the root command which
+// disables telemetry for a single invocation. An empty name uses
+// DefaultNoTelemetryFlag.
+func WithNoTelemetryFlag(name string) Option {
+	return option(func(cfg *Config) error {
+		if name == "" {
+			name = DefaultNoTelemetryFlag
+		}
+		cfg.NoTelemetryFlag = name
+		return nil
+	})
+}
+
+// WithHiddenNoTelemetryFlag is the same as WithNoTelemetryFlag, but the
+// flag is hidden from help output
+func WithHiddenNoTelemetryFlag(name string) Option {
+	return option(func(cfg *Config) error {
+		if name == "" {
+			name = DefaultNoTelemetryFlag
+		}
+		cfg.NoTelemetryFlag = name
+		cfg.NoTelemetryFlagHidden = true
+		return nil
+	})
+}
+
+// WithCompletionMetrics records shell completion requests as their own
+// metric, keyed by the command being completed
+func WithCompletionMetrics() Option {
+	return option(func(cfg *Config) error {
+		cfg.CompletionMetrics = true
+		return nil
+	})
+}
+
+// WithInventoryCommand adds a hidden command to the root command which
+// prints an inventory of all commands and flags as JSON. An empty name
+// uses DefaultInventoryCommand.
+func WithInventoryCommand(name string) Option {
+	return option(func(cfg *Config) error {
+		if name == "" {
+			name = DefaultInventoryCommand
+		}
+		cfg.InventoryCommand = name
+		return nil
+	})
+}
+
+// WithSessionID adds a "session" attribute to every invocation, tying
+// together the commands run in a single process such as a REPL. An empty
+// id generates a random one.
+func WithSessionID(id string) Option {
+	return option(func(cfg *Config) error {
+		if id == "" {
+			var err error
+			id, err = newSessionID()
+			if err != nil {
+				return fmt.Errorf("failed to generate session id: %w", err)
+			}
+		}
+		cfg.SessionID = id
+		return nil
+	})
+}
+
+// WithExportInterval sets how often metrics are exported while a command
+// marked with AnnotationLongRunning runs. Zero uses DefaultExportInterval.
+func WithExportInterval(interval time.Duration) Option {
+	return option(func(cfg *Config) error {
+		if interval == 0 {
+			interval = DefaultExportInterval
+		}
+		cfg.ExportInterval = interval
+		return nil
+	})
+}
+
+// WithPrometheusEndpoint serves the metrics on a local /metrics endpoint in
+// the Prometheus exposition format while commands marked with
+// AnnotationPrometheus run. An empty address uses DefaultPrometheusAddress.
+func WithPrometheusEndpoint(address string) Option {
+	return option(func(cfg *Config) error {
+		if address == "" {
+			address = DefaultPrometheusAddress
+		}
+		cfg.PrometheusAddress = address
+		return nil
+	})
+}
+
+// WithTemporality sets the preferred temporality of the exported metrics,
+// defaults to TemporalityDelta
+func WithTemporality(temporality Temporality) Option {
+	return option(func(cfg *Config) error {
+		cfg.Temporality = temporality
+		return nil
+	})
+}
+
+// WithAggregationSelector sets the aggregation used for each kind of
+// instrument, for example to use exponential histograms
+func WithAggregationSelector(selector metricSdk.AggregationSelector) Option {
+	return option(func(cfg *Config) error {
+		cfg.AggregationSelector = selector
+		return nil
+	})
+}
+
+// WithView registers views on the provider to rename metrics, drop
+// attributes or change histogram buckets. Can be passed multiple times.
+func WithView(views ...metricSdk.View) Option {
+	return option(func(cfg *Config) error {
+		cfg.Views = append(cfg.Views, views...)
+		return nil
+	})
+}
+
+// WithNamingScheme sets how the built-in metrics and their attributes are
+// named, defaults to NamingSchemeLegacy
+func WithNamingScheme(scheme NamingScheme) Option {
+	return option(func(cfg *Config) error {
+		cfg.NamingScheme = scheme
+		return nil
+	})
+}
+
+// WithMetricDefinition replaces the name, description or unit of a
+// built-in metric. Empty fields keep the naming scheme's value.
+func WithMetricDefinition(m BuiltinMetric, definition MetricDefinition) Option {
+	return option(func(cfg *Config) error {
+		if cfg.MetricOverrides == nil {
+			cfg.MetricOverrides = map[BuiltinMetric]MetricDefinition{}
+		}
+		cfg.MetricOverrides[m] = definition
+		return nil
+	})
+}
+
+// WithCardinalityLimit caps the distinct attribute sets recorded per metric
+// in a single execution, folding any more into the otel.metric.overflow
+// series. Defaults to DefaultCardinalityLimit, zero means no limit.
+func WithCardinalityLimit(limit int) Option {
+	return option(func(cfg *Config) error {
+		cfg.CardinalityLimit = limit
+		return nil
+	})
+}
+
+// WithAttributeProcessor runs the processors in order over every attribute
+// set recorded, built-in and custom, before it reaches the reader. Can be
+// passed multiple times.
+func WithAttributeProcessor(processors ...AttributeProcessor) Option {
+	return option(func(cfg *Config) error {
+		cfg.AttributeProcessors = append(cfg.AttributeProcessors, processors...)
+		return nil
+	})
+}
+
+// WithHashedAttributes replaces the values of the attribute keys with a
+// hash salted with a per-install salt, which is created the first time
+func WithHashedAttributes(keys ...string) Option {
+	return option(func(cfg *Config) error {
+		cfg.HashedAttributes = append(cfg.HashedAttributes, keys...)
+		return nil
+	})
+}
+
+// WithSampleRate samples invocations at a rate greater than 0 and at most
+// 1, recording the rate as an attribute so backends can re-weight counts.
+// Use the sample rate annotation or disable telemetry to never sample.
+func WithSampleRate(rate float64) Option {
+	return option(func(cfg *Config) error {
+		// Zero means unset in the config, which samples everything
+		if rate <= 0 || rate > 1 {
+			return ErrSampleRateInvalid
+		}
+		cfg.SampleRate = rate
+		return nil
+	})
+}
+
+// WithRateLimit samples at most limit invocations per window, counted
+// across processes, recording the rate invocations were sampled at as an
+// attribute so backends can re-weight counts
+func WithRateLimit(limit int, window time.Duration) Option {
+	return option(func(cfg *Config) error {
+		cfg.RateLimit = limit
+		cfg.RateLimitWindow = window
+		return nil
+	})
+}
+
+// WithLocalAggregation merges every invocation's metrics into an on-disk
+// aggregate, only exporting a rollup once the window has passed or the
+// aggregate is over maxSize bytes. Zero uses DefaultAggregationMaxSize.
+func WithLocalAggregation(window time.Duration, maxSize int) Option {
+	return option(func(cfg *Config) error {
+		if maxSize == 0 {
+			maxSize = DefaultAggregationMaxSize
+		}
+		cfg.AggregationWindow = window
+		cfg.AggregationMaxSize = maxSize
+		return nil
+	})
+}
+
+// WithResourceMetrics records the user and system CPU time, max RSS and
+// wall time of every invocation as histograms tagged with the command
+func WithResourceMetrics() Option {
+	return option(func(cfg *Config) error {
+		cfg.ResourceMetrics = true
+		return nil
+	})
+}
+
+// WithRuntimeMetrics records the GC pause time, heap allocated, GC cycles
+// and goroutine high-water mark of every invocation as histograms tagged
+// with the command
+func WithRuntimeMetrics() Option {
+	return option(func(cfg *Config) error {
+		cfg.RuntimeMetrics = true
+		return nil
+	})
+}
+
+// WithStartupMetrics records the time from process start to the command
+// running, and from the command's Run completing to the process exiting,
+// as histograms tagged with the command
+func WithStartupMetrics() Option {
+	return option(func(cfg *Config) error {
+		cfg.StartupMetrics = true
+		return nil
+	})
+}
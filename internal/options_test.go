@@ -1,7 +1,14 @@
 package internal
 
 import (
+	"bytes"
+	"errors"
+	"strings"
 	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	metricSdk "go.opentelemetry.io/otel/sdk/metric"
 )
 
 func TestWithExporter(t *testing.T) {
@@ -142,3 +149,296 @@ func TestApplierInterface(t *testing.T) {
 		t.Errorf("Expected service name 'test-applier', got %s", config.ServiceName)
 	}
 }
+
+func TestWithConsentOptions(t *testing.T) {
+	in := strings.NewReader("y\n")
+	out := &bytes.Buffer{}
+	config := &Config{}
+
+	opts := []Option{
+		WithConsentInput(in),
+		WithConsentOutput(out),
+		WithConsentTimeout(5 * time.Second),
+		WithConsentMaxRetries(1),
+	}
+	for _, opt := range opts {
+		if err := opt.apply(config); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+
+	if config.ConsentInput != in {
+		t.Error("Expected consent input to match")
+	}
+
+	if config.ConsentOutput != out {
+		t.Error("Expected consent output to match")
+	}
+
+	if config.ConsentTimeout != 5*time.Second {
+		t.Errorf("Expected consent timeout 5s, got %v", config.ConsentTimeout)
+	}
+
+	if config.ConsentMaxRetries != 1 {
+		t.Errorf("Expected consent max retries 1, got %d", config.ConsentMaxRetries)
+	}
+}
+
+func TestWithStrictConsentOptions(t *testing.T) {
+	config := &Config{}
+
+	opts := []Option{
+		WithConsentMode(ConsentModeStrict),
+		WithOptInEnvVar("MY_OPTIN"),
+		WithOptInFlag("metrics"),
+	}
+	for _, opt := range opts {
+		if err := opt.apply(config); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+
+	if config.ConsentMode != ConsentModeStrict {
+		t.Errorf("Expected strict consent mode, got %v", config.ConsentMode)
+	}
+
+	if config.OptInEnvVar != "MY_OPTIN" {
+		t.Errorf("Expected opt-in env var 'MY_OPTIN', got %s", config.OptInEnvVar)
+	}
+
+	if config.OptInFlag != "metrics" {
+		t.Errorf("Expected opt-in flag 'metrics', got %s", config.OptInFlag)
+	}
+}
+
+func TestWithSessionID(t *testing.T) {
+	config := &Config{}
+	if err := WithSessionID("my-session").apply(config); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if config.SessionID != "my-session" {
+		t.Errorf("Expected session id 'my-session', got %s", config.SessionID)
+	}
+
+	generated := &Config{}
+	if err := WithSessionID("").apply(generated); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if len(generated.SessionID) != 32 {
+		t.Errorf("Expected a generated 32 character session id, got %q", generated.SessionID)
+	}
+
+	other := &Config{}
+	WithSessionID("").apply(other)
+	if other.SessionID == generated.SessionID {
+		t.Error("Expected generated session ids to be unique")
+	}
+}
+
+func TestWithExportInterval(t *testing.T) {
+	config := &Config{}
+	if err := WithExportInterval(5 * time.Second).apply(config); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if config.ExportInterval != 5*time.Second {
+		t.Errorf("Expected export interval 5s, got %v", config.ExportInterval)
+	}
+
+	if err := WithExportInterval(0).apply(config); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if config.ExportInterval != DefaultExportInterval {
+		t.Errorf("Expected default export interval, got %v", config.ExportInterval)
+	}
+}
+
+func TestWithPrometheusEndpoint(t *testing.T) {
+	config := &Config{}
+	if err := WithPrometheusEndpoint("0.0.0.0:9000").apply(config); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if config.PrometheusAddress != "0.0.0.0:9000" {
+		t.Errorf("Expected prometheus address '0.0.0.0:9000', got %s", config.PrometheusAddress)
+	}
+
+	if err := WithPrometheusEndpoint("").apply(config); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if config.PrometheusAddress != DefaultPrometheusAddress {
+		t.Errorf("Expected default prometheus address, got %s", config.PrometheusAddress)
+	}
+}
+
+func TestWithTemporalityAndViews(t *testing.T) {
+	config := &Config{}
+	view := metricSdk.NewView(
+		metricSdk.Instrument{Name: "cli-*"},
+		metricSdk.Stream{AttributeFilter: attribute.NewDenyKeysFilter("tty")},
+	)
+
+	opts := []Option{
+		WithTemporality(TemporalityCumulative),
+		WithAggregationSelector(metricSdk.DefaultAggregationSelector),
+		WithView(view),
+		WithView(view, view),
+	}
+	for _, opt := range opts {
+		if err := opt.apply(config); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+
+	if config.Temporality != TemporalityCumulative {
+		t.Errorf("Expected temporality %q, got %q", TemporalityCumulative, config.Temporality)
+	}
+
+	if config.AggregationSelector == nil {
+		t.Error("Expected aggregation selector to be set")
+	}
+
+	if len(config.Views) != 3 {
+		t.Errorf("Expected 3 views, got %d", len(config.Views))
+	}
+}
+
+func TestWithNamingOptions(t *testing.T) {
+	config := &Config{}
+	opts := []Option{
+		WithNamingScheme(NamingSchemeSemconv),
+		WithMetricDefinition(MetricInvocations, MetricDefinition{Name: "acme.cli.runs"}),
+		WithMetricDefinition(MetricErrors, MetricDefinition{Unit: "{failure}"}),
+	}
+	for _, opt := range opts {
+		if err := opt.apply(config); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+
+	if config.NamingScheme != NamingSchemeSemconv {
+		t.Errorf("Expected semconv naming scheme, got %v", config.NamingScheme)
+	}
+
+	if len(config.MetricOverrides) != 2 {
+		t.Errorf("Expected 2 metric overrides, got %d", len(config.MetricOverrides))
+	}
+
+	if config.MetricOverrides[MetricInvocations].Name != "acme.cli.runs" {
+		t.Errorf("Expected invocations override 'acme.cli.runs', got %s", config.MetricOverrides[MetricInvocations].Name)
+	}
+}
+
+func TestWithCardinalityLimit(t *testing.T) {
+	config := &Config{}
+	if err := WithCardinalityLimit(10).apply(config); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if config.CardinalityLimit != 10 {
+		t.Errorf("Expected cardinality limit 10, got %d", config.CardinalityLimit)
+	}
+}
+
+func TestWithAttributeProcessing(t *testing.T) {
+	config := &Config{}
+	opts := []Option{
+		WithAttributeProcessor(DropAttributes("tty")),
+		WithAttributeProcessor(ScrubAttributes(), DropAttributes("session")),
+		WithHashedAttributes("team"),
+	}
+	for _, opt := range opts {
+		if err := opt.apply(config); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+
+	if len(config.AttributeProcessors) != 3 {
+		t.Errorf("Expected 3 attribute processors, got %d", len(config.AttributeProcessors))
+	}
+
+	if len(config.HashedAttributes) != 1 || config.HashedAttributes[0] != "team" {
+		t.Errorf("Expected hashed attributes [team], got %v", config.HashedAttributes)
+	}
+}
+
+func TestWithSampling(t *testing.T) {
+	config := &Config{}
+	opts := []Option{
+		WithSampleRate(0.1),
+		WithRateLimit(100, time.Hour),
+	}
+	for _, opt := range opts {
+		if err := opt.apply(config); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+
+	if config.SampleRate != 0.1 {
+		t.Errorf("Expected sample rate 0.1, got %v", config.SampleRate)
+	}
+
+	if config.RateLimit != 100 || config.RateLimitWindow != time.Hour {
+		t.Errorf("Expected rate limit 100 per hour, got %d per %v", config.RateLimit, config.RateLimitWindow)
+	}
+}
+
+func TestWithSampleRateInvalid(t *testing.T) {
+	for _, rate := range []float64{0, -0.5, 1.5} {
+		if err := WithSampleRate(rate).apply(&Config{}); !errors.Is(err, ErrSampleRateInvalid) {
+			t.Errorf("WithSampleRate(%v) error = %v, want %v", rate, err, ErrSampleRateInvalid)
+		}
+	}
+}
+
+func TestWithLocalAggregation(t *testing.T) {
+	config := &Config{}
+	if err := WithLocalAggregation(24*time.Hour, 0).apply(config); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if config.AggregationWindow != 24*time.Hour {
+		t.Errorf("Expected aggregation window 24h, got %v", config.AggregationWindow)
+	}
+
+	if config.AggregationMaxSize != DefaultAggregationMaxSize {
+		t.Errorf("Expected default aggregation max size, got %d", config.AggregationMaxSize)
+	}
+}
+
+func TestWithResourceMetrics(t *testing.T) {
+	config := &Config{}
+	if err := WithResourceMetrics().apply(config); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if !config.ResourceMetrics {
+		t.Errorf("Expected resource metrics to be enabled")
+	}
+}
+
+func TestWithRuntimeMetrics(t *testing.T) {
+	config := &Config{}
+	if err := WithRuntimeMetrics().apply(config); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if !config.RuntimeMetrics {
+		t.Errorf("Expected runtime metrics to be enabled")
+	}
+}
+
+func TestWithStartupMetrics(t *testing.T) {
+	config := &Config{}
+	if err := WithStartupMetrics().apply(config); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if !config.StartupMetrics {
+		t.Errorf("Expected startup metrics to be enabled")
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package internal
+
+import "time"
+
+// Input can't be polled on this platform, so it's read in the background
+func waitForInput(fd int, timeout time.Duration) (ready bool, ok bool) {
+	return false, false
+}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package internal
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// waitForInput waits until the file descriptor has input to read or the
+// timeout passes. It reports false for ok when the input can't be polled.
+func waitForInput(fd int, timeout time.Duration) (ready bool, ok bool) {
+	if fd < 0 {
+		return false, false
+	}
+
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	deadline := time.Now().Add(timeout)
+	for {
+		remaining := max(time.Until(deadline), 0)
+		n, err := unix.Poll(fds, int(remaining.Milliseconds()))
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil || fds[0].Revents&unix.POLLNVAL != 0 {
+			return false, false
+		}
+		return n > 0, true
+	}
+}
@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	otelPrometheus "go.opentelemetry.io/otel/exporters/prometheus"
+)
+
+const DefaultPrometheusAddress = "localhost:9464"
+
+var ErrPrometheusNotConfigured = errors.New("the prometheus endpoint is not configured")
+
+// PrometheusServer serves the provider's metrics on a local /metrics
+// endpoint in the Prometheus exposition format
+type PrometheusServer struct {
+	listener net.Listener
+	server   *http.Server
+}
+
+// Creates the registry and reader backing the Prometheus endpoint. The
+// reader is pull based, so it has no effect until the endpoint is served.
+// Prometheus data is always cumulative, so only the aggregation applies.
+func newPrometheusReader(config *Config) (*prometheus.Registry, *otelPrometheus.Exporter, error) {
+	registry := prometheus.NewRegistry()
+	options := []otelPrometheus.Option{otelPrometheus.WithRegisterer(registry)}
+	if config.AggregationSelector != nil {
+		options = append(options, otelPrometheus.WithAggregationSelector(config.AggregationSelector))
+	}
+	reader, err := otelPrometheus.New(options...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return registry, reader, nil
+}
+
+// Starts serving the provider's metrics on the configured address
+func (mp *MetricsProvider) ServePrometheus() (*PrometheusServer, error) {
+	if mp.PrometheusRegistry == nil {
+		return nil, ErrPrometheusNotConfigured
+	}
+
+	listener, err := net.Listen("tcp", mp.config.PrometheusAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", mp.config.PrometheusAddress, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(mp.PrometheusRegistry, promhttp.HandlerOpts{}))
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+
+	return &PrometheusServer{
+		listener: listener,
+		server:   server,
+	}, nil
+}
+
+// Returns the address the endpoint is listening on
+func (ps *PrometheusServer) Addr() string {
+	return ps.listener.Addr().String()
+}
+
+// Stops serving the endpoint
+func (ps *PrometheusServer) Shutdown(ctx context.Context) error {
+	return ps.server.Shutdown(ctx)
+}
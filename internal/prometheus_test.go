@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestServePrometheus(t *testing.T) {
+	ctx := context.Background()
+	provider, err := NewMetricsProvider(ctx, &Config{
+		ServiceName:       "test-service",
+		PrometheusAddress: "127.0.0.1:0",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer provider.Shutdown(ctx)
+
+	if provider.PrometheusRegistry == nil {
+		t.Fatal("Expected prometheus registry to be set")
+	}
+
+	counter, err := provider.GetMeter().Int64Counter("scraped")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	counter.Add(ctx, 3)
+
+	server, err := provider.ServePrometheus()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer server.Shutdown(ctx)
+
+	resp, err := http.Get("http://" + server.Addr() + "/metrics")
+	if err != nil {
+		t.Fatalf("Unexpected error scraping metrics: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "scraped_total") {
+		t.Errorf("Expected scraped metric in exposition, got:\n%s", body)
+	}
+}
+
+func TestServePrometheusNotConfigured(t *testing.T) {
+	ctx := context.Background()
+	provider, err := NewMetricsProvider(ctx, &Config{ServiceName: "test-service"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer provider.Shutdown(ctx)
+
+	_, err = provider.ServePrometheus()
+	if !errors.Is(err, ErrPrometheusNotConfigured) {
+		t.Errorf("Expected ErrPrometheusNotConfigured, got %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const defaultSaltFilenamePostfix = "metrics-salt"
+
+// AttributeProcessor rewrites the attribute set recorded for an instrument.
+// Processors see every set recorded through the provider's meter, from the
+// built-in metrics and from any the CLI records itself.
+type AttributeProcessor func(instrument string, set attribute.Set) attribute.Set
+
+// Scrubber replaces the parts of string attribute values that match the
+// pattern. The replacement can refer to submatches like regexp.ReplaceAllString.
+type Scrubber struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// Built in scrubbers for values which commonly leak personal data
+var (
+	ScrubEmails = Scrubber{
+		Pattern:     regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+		Replacement: "<email>",
+	}
+	ScrubIPs = Scrubber{
+		Pattern:     regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b|\b(?:[0-9A-Fa-f]{1,4}:){2,7}[0-9A-Fa-f]{1,4}\b`),
+		Replacement: "<ip>",
+	}
+	ScrubPaths = Scrubber{
+		Pattern:     regexp.MustCompile(`(^|[\s=:"'])((?:~|[A-Za-z]:)?[/\\][^\s"']*)`),
+		Replacement: "${1}<path>",
+	}
+	ScrubTokens = Scrubber{
+		Pattern: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{20,}|xox[abprs]-[A-Za-z0-9-]+|AKIA[0-9A-Z]{16}|eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+)\b` +
+			`|(?i:\b(?:bearer|token|secret|password|api[_-]?key)\b[=: ]+\S+)`),
+		Replacement: "<token>",
+	}
+
+	DefaultScrubbers = []Scrubber{ScrubEmails, ScrubTokens, ScrubIPs, ScrubPaths}
+)
+
+// DropAttributes removes the keys from every attribute set
+func DropAttributes(keys ...string) AttributeProcessor {
+	drop := map[attribute.Key]bool{}
+	for _, key := range keys {
+		drop[attribute.Key(key)] = true
+	}
+
+	return func(instrument string, set attribute.Set) attribute.Set {
+		filtered, _ := set.Filter(func(kv attribute.KeyValue) bool {
+			return !drop[kv.Key]
+		})
+		return filtered
+	}
+}
+
+// ScrubAttributes runs the scrubbers over every string value in every
+// attribute set. With no scrubbers DefaultScrubbers are used.
+func ScrubAttributes(scrubbers ...Scrubber) AttributeProcessor {
+	if len(scrubbers) == 0 {
+		scrubbers = DefaultScrubbers
+	}
+
+	scrub := func(value string) string {
+		for _, scrubber := range scrubbers {
+			value = scrubber.Pattern.ReplaceAllString(value, scrubber.Replacement)
+		}
+		return value
+	}
+
+	return func(instrument string, set attribute.Set) attribute.Set {
+		return mapAttributeValues(set, func(kv attribute.KeyValue) attribute.KeyValue {
+			switch kv.Value.Type() {
+			case attribute.STRING:
+				return kv.Key.String(scrub(kv.Value.AsString()))
+			case attribute.STRINGSLICE:
+				values := kv.Value.AsStringSlice()
+				for i, value := range values {
+					values[i] = scrub(value)
+				}
+				return kv.Key.StringSlice(values)
+			default:
+				return kv
+			}
+		})
+	}
+}
+
+// HashAttributes replaces the values of the keys with a salted hash, so
+// values can still be counted and told apart without being readable
+func HashAttributes(salt []byte, keys ...string) AttributeProcessor {
+	hashed := map[attribute.Key]bool{}
+	for _, key := range keys {
+		hashed[attribute.Key(key)] = true
+	}
+
+	return func(instrument string, set attribute.Set) attribute.Set {
+		return mapAttributeValues(set, func(kv attribute.KeyValue) attribute.KeyValue {
+			if !hashed[kv.Key] {
+				return kv
+			}
+			mac := hmac.New(sha256.New, salt)
+			mac.Write([]byte(kv.Value.Emit()))
+			return kv.Key.String(hex.EncodeToString(mac.Sum(nil)[:16]))
+		})
+	}
+}
+
+func mapAttributeValues(set attribute.Set, f func(attribute.KeyValue) attribute.KeyValue) attribute.Set {
+	attributes := set.ToSlice()
+	for i, kv := range attributes {
+		attributes[i] = f(kv)
+	}
+	return attribute.NewSet(attributes...)
+}
+
+// Reads the per-install salt used to hash attribute values, creating it
+// the first time. A salt that can't be kept is still returned along with
+// the error, so values are hashed with a salt only this process uses.
+// An empty path never keeps it.
+func loadOrCreateSalt(filePath string) ([]byte, error) {
+	var loadErr error
+	if filePath != "" {
+		salt, err := os.ReadFile(filePath)
+		if err == nil && len(salt) > 0 {
+			return salt, nil
+		}
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			loadErr = err
+		}
+	}
+
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	if filePath == "" || loadErr != nil {
+		return salt, loadErr
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return salt, err
+	}
+	if err := os.WriteFile(filePath, salt, 0600); err != nil {
+		return salt, err
+	}
+	return salt, nil
+}
+
+func getDefaultSaltFilePath(cmdName string) string {
+	return getDefaultConsentFilePath(cmdName, defaultSaltFilenamePostfix)
+}
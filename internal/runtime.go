@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"math"
+	"runtime/metrics"
+	"sync"
+	"time"
+)
+
+// How often the goroutine count is sampled for its high-water mark
+const runtimeSampleInterval = 10 * time.Millisecond
+
+const (
+	gcPausesMetric   = "/sched/pauses/total/gc:seconds"
+	heapAllocsMetric = "/gc/heap/allocs:bytes"
+	gcCyclesMetric   = "/gc/cycles/total:gc-cycles"
+	goroutinesMetric = "/sched/goroutines:goroutines"
+)
+
+// RuntimeStats is what the Go runtime did during an invocation
+type RuntimeStats struct {
+	// Total time the world was stopped for GC, estimated from the
+	// runtime's pause histogram
+	GCPauses time.Duration
+
+	// Bytes allocated on the heap
+	HeapAllocated uint64
+
+	// Completed GC cycles
+	GCCycles uint64
+
+	// Highest number of goroutines seen
+	MaxGoroutines uint64
+}
+
+// RuntimeSampler reads runtime/metrics when it's started and stopped,
+// sampling the goroutine count in between
+type RuntimeSampler struct {
+	start []metrics.Sample
+
+	mu            sync.Mutex
+	maxGoroutines uint64
+
+	stopOnce sync.Once
+	done     chan struct{}
+	stopped  chan struct{}
+	stats    RuntimeStats
+}
+
+// StartRuntimeSampler takes the starting reading and starts sampling the
+// goroutine count
+func StartRuntimeSampler() *RuntimeSampler {
+	s := &RuntimeSampler{
+		start:   readRuntimeMetrics(),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	s.maxGoroutines = goroutines(s.start)
+
+	go func() {
+		defer close(s.stopped)
+		ticker := time.NewTicker(runtimeSampleInterval)
+		defer ticker.Stop()
+
+		// The other samples only need the start and end readings, so
+		// only the goroutine count is read while polling
+		sample := []metrics.Sample{{Name: goroutinesMetric}}
+		for {
+			select {
+			case <-ticker.C:
+				metrics.Read(sample)
+				// don't count the sampler itself
+				if n := goroutines(sample); n > 0 {
+					s.observeGoroutines(n - 1)
+				}
+			case <-s.done:
+				return
+			}
+		}
+	}()
+
+	return s
+}
+
+func (s *RuntimeSampler) observeGoroutines(n uint64) {
+	s.mu.Lock()
+	s.maxGoroutines = max(s.maxGoroutines, n)
+	s.mu.Unlock()
+}
+
+// Stop stops sampling and returns the stats since the sampler started.
+// Only the first call takes the ending reading.
+func (s *RuntimeSampler) Stop() RuntimeStats {
+	s.stopOnce.Do(func() {
+		close(s.done)
+		<-s.stopped
+
+		end := readRuntimeMetrics()
+		s.observeGoroutines(goroutines(end))
+		s.stats = runtimeStatsBetween(s.start, end)
+
+		s.mu.Lock()
+		s.stats.MaxGoroutines = s.maxGoroutines
+		s.mu.Unlock()
+	})
+	return s.stats
+}
+
+func readRuntimeMetrics() []metrics.Sample {
+	samples := []metrics.Sample{
+		{Name: gcPausesMetric},
+		{Name: heapAllocsMetric},
+		{Name: gcCyclesMetric},
+		{Name: goroutinesMetric},
+	}
+	metrics.Read(samples)
+	return samples
+}
+
+func runtimeStatsBetween(start, end []metrics.Sample) RuntimeStats {
+	stats := RuntimeStats{}
+	for i := range end {
+		startValue, endValue := start[i].Value, end[i].Value
+		switch end[i].Name {
+		case gcPausesMetric:
+			if endValue.Kind() == metrics.KindFloat64Histogram {
+				stats.GCPauses = pauseTotal(startValue.Float64Histogram(), endValue.Float64Histogram())
+			}
+		case heapAllocsMetric:
+			stats.HeapAllocated = uint64Delta(startValue, endValue)
+		case gcCyclesMetric:
+			stats.GCCycles = uint64Delta(startValue, endValue)
+		}
+	}
+	return stats
+}
+
+func goroutines(samples []metrics.Sample) uint64 {
+	for _, sample := range samples {
+		if sample.Name == goroutinesMetric && sample.Value.Kind() == metrics.KindUint64 {
+			return sample.Value.Uint64()
+		}
+	}
+	return 0
+}
+
+func uint64Delta(start, end metrics.Value) uint64 {
+	if start.Kind() != metrics.KindUint64 || end.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return end.Uint64() - start.Uint64()
+}
+
+// The runtime only keeps a histogram of pauses, so the total is estimated
+// from the middle of the bucket each new pause fell into
+func pauseTotal(start, end *metrics.Float64Histogram) time.Duration {
+	total := 0.0
+	for i, count := range end.Counts {
+		if i < len(start.Counts) {
+			count -= start.Counts[i]
+		}
+		if count == 0 {
+			continue
+		}
+		total += float64(count) * bucketMidpoint(end.Buckets[i], end.Buckets[i+1])
+	}
+	return time.Duration(total * float64(time.Second))
+}
+
+func bucketMidpoint(lower, upper float64) float64 {
+	switch {
+	case math.IsInf(lower, -1):
+		return upper
+	case math.IsInf(upper, 1):
+		return lower
+	default:
+		return (lower + upper) / 2
+	}
+}
@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"math"
+	"runtime"
+	"runtime/metrics"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPauseTotal(t *testing.T) {
+	buckets := []float64{math.Inf(-1), 0.001, 0.002, 0.004, math.Inf(1)}
+
+	tests := []struct {
+		name     string
+		start    []uint64
+		end      []uint64
+		expected time.Duration
+	}{
+		{
+			name:     "no new pauses",
+			start:    []uint64{0, 3, 1, 0},
+			end:      []uint64{0, 3, 1, 0},
+			expected: 0,
+		},
+		{
+			name:     "pauses in finite buckets",
+			start:    []uint64{0, 3, 1, 0},
+			end:      []uint64{0, 5, 2, 0},
+			expected: 2*1500*time.Microsecond + 3*time.Millisecond,
+		},
+		{
+			name:     "pauses in unbounded buckets",
+			start:    []uint64{0, 0, 0, 0},
+			end:      []uint64{1, 0, 0, 2},
+			expected: time.Millisecond + 2*4*time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := &metrics.Float64Histogram{Counts: tt.start, Buckets: buckets}
+			end := &metrics.Float64Histogram{Counts: tt.end, Buckets: buckets}
+			got := pauseTotal(start, end)
+			if diff := got - tt.expected; diff < -time.Nanosecond || diff > time.Nanosecond {
+				t.Errorf("pauseTotal() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+var allocSink [][]byte
+
+func TestRuntimeSampler(t *testing.T) {
+	sampler := StartRuntimeSampler()
+
+	for i := 0; i < 100; i++ {
+		allocSink = append(allocSink, make([]byte, 64<<10))
+	}
+	runtime.GC()
+
+	var wg sync.WaitGroup
+	release := make(chan struct{})
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-release
+		}()
+	}
+	time.Sleep(5 * runtimeSampleInterval)
+	close(release)
+	wg.Wait()
+	allocSink = nil
+
+	stats := sampler.Stop()
+	if stats.HeapAllocated < 100*64<<10 {
+		t.Errorf("Stop() HeapAllocated = %d, want at least %d", stats.HeapAllocated, 100*64<<10)
+	}
+	if stats.GCCycles < 1 {
+		t.Errorf("Stop() GCCycles = %d, want at least 1", stats.GCCycles)
+	}
+	if stats.MaxGoroutines < 50 {
+		t.Errorf("Stop() MaxGoroutines = %d, want at least 50", stats.MaxGoroutines)
+	}
+
+	if again := sampler.Stop(); again != stats {
+		t.Errorf("Stop() = %+v on the second call, want %+v", again, stats)
+	}
+}
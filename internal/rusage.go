@@ -0,0 +1,22 @@
+package internal
+
+import "time"
+
+// ResourceUsage is the CPU time and memory used by the process
+type ResourceUsage struct {
+	UserTime   time.Duration
+	SystemTime time.Duration
+
+	// Highest resident set size of the process so far, in bytes
+	MaxRSS int64
+}
+
+// Sub returns the CPU time used since the earlier usage. Max RSS is a
+// high-water mark for the whole process, so it's kept as is.
+func (u ResourceUsage) Sub(earlier ResourceUsage) ResourceUsage {
+	return ResourceUsage{
+		UserTime:   u.UserTime - earlier.UserTime,
+		SystemTime: u.SystemTime - earlier.SystemTime,
+		MaxRSS:     u.MaxRSS,
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package internal
+
+// ReadResourceUsage isn't supported on this platform, so only the wall
+// time is recorded
+func ReadResourceUsage() (ResourceUsage, bool) {
+	return ResourceUsage{}, false
+}
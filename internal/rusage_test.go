@@ -0,0 +1,27 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResourceUsageSub(t *testing.T) {
+	earlier := ResourceUsage{UserTime: time.Second, SystemTime: 200 * time.Millisecond, MaxRSS: 1 << 20}
+	later := ResourceUsage{UserTime: 3 * time.Second, SystemTime: 500 * time.Millisecond, MaxRSS: 4 << 20}
+
+	got := later.Sub(earlier)
+	want := ResourceUsage{UserTime: 2 * time.Second, SystemTime: 300 * time.Millisecond, MaxRSS: 4 << 20}
+	if got != want {
+		t.Errorf("Sub() = %+v, want %+v", got, want)
+	}
+}
+
+func TestReadResourceUsage(t *testing.T) {
+	usage, ok := ReadResourceUsage()
+	if !ok {
+		t.Skip("resource usage isn't supported on this platform")
+	}
+	if usage.MaxRSS <= 0 {
+		t.Errorf("ReadResourceUsage() MaxRSS = %d, want > 0", usage.MaxRSS)
+	}
+}
@@ -0,0 +1,29 @@
+//go:build unix
+
+package internal
+
+import (
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// ReadResourceUsage reads the CPU time and max RSS used by the process so far
+func ReadResourceUsage() (ResourceUsage, bool) {
+	var rusage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &rusage); err != nil {
+		return ResourceUsage{}, false
+	}
+
+	// Max RSS is reported in bytes on darwin and kilobytes everywhere else
+	maxRSS := int64(rusage.Maxrss)
+	if runtime.GOOS != "darwin" && runtime.GOOS != "ios" {
+		maxRSS *= 1024
+	}
+
+	return ResourceUsage{
+		UserTime:   time.Duration(rusage.Utime.Nano()),
+		SystemTime: time.Duration(rusage.Stime.Nano()),
+		MaxRSS:     maxRSS,
+	}, true
+}
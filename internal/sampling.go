@@ -0,0 +1,181 @@
+package internal
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const defaultRateLimitFilenamePostfix = "metrics-ratelimit"
+
+// Swapped out in tests
+var (
+	randomFloat = rand.Float64
+	now         = time.Now
+)
+
+// rateLimitState is persisted between invocations to count how many
+// invocations were seen in the current and previous windows
+type rateLimitState struct {
+	WindowStart  time.Time `json:"window_start"`
+	Seen         int       `json:"seen"`
+	PreviousSeen int       `json:"previous_seen"`
+}
+
+// sampler decides if invocations are sampled and adds the rate they
+// were sampled at to every attribute set recorded in the invocation
+type sampler struct {
+	config *Config
+
+	mu   sync.Mutex
+	rate float64
+}
+
+func newSampler(config *Config) *sampler {
+	return &sampler{config: config, rate: 1}
+}
+
+// Determines if sampling is configured at all, otherwise the sample rate
+// attribute is never added
+func (c *Config) isSamplingConfigured() bool {
+	return c.SampleRate != 0 || c.RateLimit > 0
+}
+
+// Decides if the invocation of the command is sampled
+func (s *sampler) sample(cmd *cobra.Command) bool {
+	rate := s.sampleRate(cmd)
+
+	s.mu.Lock()
+	s.rate = rate
+	s.mu.Unlock()
+
+	return randomFloat() < rate
+}
+
+// Returns the rate the command's invocations are sampled at, from
+// either its annotation or the configured sample rate and rate limit
+func (s *sampler) sampleRate(cmd *cobra.Command) float64 {
+	if value, ok := lookupAnnotation(cmd, AnnotationSampleRate); ok {
+		if rate, err := strconv.ParseFloat(value, 64); err == nil && rate >= 0 && rate <= 1 {
+			return rate
+		}
+	}
+
+	rate := 1.0
+	if s.config.SampleRate != 0 {
+		rate = s.config.SampleRate
+	}
+	if s.config.RateLimit > 0 {
+		rate *= s.rateLimitRate()
+	}
+	return rate
+}
+
+// Counts the invocation towards the rate limit and returns the rate that
+// keeps invocations under it, based on how many were seen in the previous
+// window or so far in this one
+func (s *sampler) rateLimitRate() float64 {
+	// Invocations can only be counted if there's somewhere to keep the count
+	if s.config.RateLimitFile == "" {
+		return 1
+	}
+
+	// Concurrent invocations would otherwise lose each other's counts
+	if unlock, err := lockFile(s.config.RateLimitFile); err == nil {
+		defer unlock()
+	}
+
+	state := rateLimitState{}
+	if data, err := os.ReadFile(s.config.RateLimitFile); err == nil {
+		json.Unmarshal(data, &state)
+	}
+
+	current := now()
+	if elapsed := current.Sub(state.WindowStart); elapsed >= s.config.RateLimitWindow || elapsed < 0 {
+		// Only the window right before this one says anything about
+		// the current rate of invocations
+		if elapsed < 2*s.config.RateLimitWindow && elapsed >= 0 {
+			state.PreviousSeen = state.Seen
+		} else {
+			state.PreviousSeen = 0
+		}
+		state.Seen = 0
+		state.WindowStart = current
+	}
+	state.Seen++
+
+	if data, err := json.Marshal(state); err == nil {
+		writeFileAtomic(s.config.RateLimitFile, data)
+	}
+
+	seen := max(state.Seen, state.PreviousSeen)
+	if seen <= s.config.RateLimit {
+		return 1
+	}
+	return quantizeRate(float64(s.config.RateLimit) / float64(seen))
+}
+
+// Rounds the rate down to a power of two. The rate is recorded as an
+// attribute, so an exact limit/seen rate would add a new series to every
+// metric for almost every invocation over the limit.
+func quantizeRate(rate float64) float64 {
+	if rate >= 1 {
+		return 1
+	}
+	if rate <= 0 {
+		return 0
+	}
+	return math.Exp2(math.Floor(math.Log2(rate)))
+}
+
+// Adds the sample rate of the current invocation to the attribute set
+func (s *sampler) process(instrument string, set attribute.Set) attribute.Set {
+	s.mu.Lock()
+	rate := s.rate
+	s.mu.Unlock()
+
+	attributes := append(set.ToSlice(), s.config.AttributeKey(AttributeSampleRate).Float64(rate))
+	return attribute.NewSet(attributes...)
+}
+
+// Writes the file through a temporary file so concurrent invocations
+// never read a partially written file
+func writeFileAtomic(filePath string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filePath)
+}
+
+// Opens the lock file that guards the file against concurrent invocations
+func openLockFile(filePath string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(filePath+".lock", os.O_CREATE|os.O_RDWR, 0644)
+}
+
+func getDefaultRateLimitFilePath(cmdName string) string {
+	return getDefaultConsentFilePath(cmdName, defaultRateLimitFilenamePostfix)
+}
@@ -0,0 +1,150 @@
+package internal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestSamplerSampleRate(t *testing.T) {
+	root := &cobra.Command{Use: "my-cli"}
+	always := &cobra.Command{Use: "login", Annotations: map[string]string{AnnotationSampleRate: "1"}}
+	rarely := &cobra.Command{Use: "ci", Annotations: map[string]string{AnnotationSampleRate: "0.01"}}
+	invalid := &cobra.Command{Use: "bad", Annotations: map[string]string{AnnotationSampleRate: "2"}}
+	inherited := &cobra.Command{Use: "run"}
+	root.AddCommand(always, rarely, invalid)
+	rarely.AddCommand(inherited)
+
+	tests := []struct {
+		name     string
+		config   *Config
+		cmd      *cobra.Command
+		expected float64
+	}{
+		{name: "unset", config: &Config{}, cmd: root, expected: 1},
+		{name: "configured", config: &Config{SampleRate: 0.25}, cmd: root, expected: 0.25},
+		{name: "annotation overrides", config: &Config{SampleRate: 0.25}, cmd: always, expected: 1},
+		{name: "annotation", config: &Config{}, cmd: rarely, expected: 0.01},
+		{name: "inherited annotation", config: &Config{}, cmd: inherited, expected: 0.01},
+		{name: "invalid annotation", config: &Config{SampleRate: 0.5}, cmd: invalid, expected: 0.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newSampler(tt.config).sampleRate(tt.cmd); got != tt.expected {
+				t.Errorf("sampleRate() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSamplerSample(t *testing.T) {
+	defer func(original func() float64) { randomFloat = original }(randomFloat)
+	randomFloat = func() float64 { return 0.3 }
+
+	cmd := &cobra.Command{Use: "my-cli"}
+
+	if !newSampler(&Config{SampleRate: 0.5}).sample(cmd) {
+		t.Error("Expected invocation to be sampled under the rate")
+	}
+
+	s := newSampler(&Config{SampleRate: 0.2})
+	if s.sample(cmd) {
+		t.Error("Expected invocation not to be sampled over the rate")
+	}
+
+	set := s.process("test", attribute.NewSet(attribute.String("command", "my-cli")))
+	if rate, ok := set.Value(AttributeSampleRate); !ok || rate.AsFloat64() != 0.2 {
+		t.Errorf("Expected sample_rate 0.2, got %v", rate.Emit())
+	}
+}
+
+func TestQuantizeRate(t *testing.T) {
+	tests := []struct {
+		rate     float64
+		expected float64
+	}{
+		{rate: 1, expected: 1},
+		{rate: 2, expected: 1},
+		{rate: 0.5, expected: 0.5},
+		{rate: 2.0 / 3, expected: 0.5},
+		{rate: 0.3, expected: 0.25},
+		{rate: 0.01, expected: 1.0 / 128},
+		{rate: 0, expected: 0},
+	}
+
+	for _, tt := range tests {
+		if got := quantizeRate(tt.rate); got != tt.expected {
+			t.Errorf("quantizeRate(%v) = %v, want %v", tt.rate, got, tt.expected)
+		}
+	}
+}
+
+func TestSamplerRateLimit(t *testing.T) {
+	defer func(original func() time.Time) { now = original }(now)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	s := newSampler(&Config{
+		RateLimit:       2,
+		RateLimitWindow: time.Hour,
+		RateLimitFile:   filepath.Join(t.TempDir(), ".my-cli-metrics-ratelimit"),
+	})
+
+	steps := []struct {
+		name     string
+		at       time.Duration
+		expected float64
+	}{
+		{name: "first invocation", at: 0, expected: 1},
+		{name: "at the limit", at: time.Minute, expected: 1},
+		{name: "over the limit", at: 2 * time.Minute, expected: 0.5},
+		{name: "over the limit again", at: 3 * time.Minute, expected: 0.5},
+		{name: "further over the limit", at: 4 * time.Minute, expected: 0.25},
+		{name: "next window uses previous count", at: time.Hour, expected: 0.25},
+		{name: "window after a quiet one", at: 3 * time.Hour, expected: 1},
+	}
+
+	for _, step := range steps {
+		now = func() time.Time { return start.Add(step.at) }
+		if got := s.rateLimitRate(); got != step.expected {
+			t.Errorf("%s: rateLimitRate() = %v, want %v", step.name, got, step.expected)
+		}
+	}
+}
+
+func TestSamplerRateLimitConcurrentInvocations(t *testing.T) {
+	config := &Config{
+		RateLimit:       1000,
+		RateLimitWindow: time.Hour,
+		RateLimitFile:   filepath.Join(t.TempDir(), ".my-cli-metrics-ratelimit"),
+	}
+
+	const invocations = 20
+	var wg sync.WaitGroup
+	for i := 0; i < invocations; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			newSampler(config).rateLimitRate()
+		}()
+	}
+	wg.Wait()
+
+	state := rateLimitState{}
+	data, err := os.ReadFile(config.RateLimitFile)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if state.Seen != invocations {
+		t.Errorf("Seen = %d, want %d", state.Seen, invocations)
+	}
+}
@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultExitLatencyFilenamePostfix = "metrics-exit-latency"
+
+// The kernel reports process times in USER_HZ, which is always 100
+const clockTicksPerSecond = 100
+
+// When this package was initialized, the closest we can get to the
+// process start where the OS doesn't tell us
+var initTime = time.Now()
+
+// ProcessStartTime returns when the process started, falling back to
+// when this package was initialized
+func ProcessStartTime() time.Time {
+	if start, ok := readProcessStartTime(); ok && start.Before(initTime) {
+		return start
+	}
+	return initTime
+}
+
+// Parses the start time field of /proc/self/stat, in clock ticks since boot.
+// The command name can contain spaces and parentheses, so fields are
+// counted from the last closing parenthesis.
+func parseProcStatStartTicks(stat []byte) (uint64, bool) {
+	end := bytes.LastIndexByte(stat, ')')
+	if end < 0 {
+		return 0, false
+	}
+	// the fields after the command name start at the state, field 3,
+	// and the start time is field 22
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) < 20 {
+		return 0, false
+	}
+	ticks, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return ticks, true
+}
+
+// Parses the seconds since boot from /proc/uptime
+func parseUptime(uptime []byte) (time.Duration, bool) {
+	fields := strings.Fields(string(uptime))
+	if len(fields) == 0 {
+		return 0, false
+	}
+	seconds, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(seconds * float64(time.Second)), true
+}
+
+// exitLatency is persisted for the next invocation to report, as the
+// process has exited by the time it's known
+type exitLatency struct {
+	Command string        `json:"command"`
+	Latency time.Duration `json:"latency"`
+}
+
+// SaveExitLatency persists the time from the command's Run completing to
+// the process exiting, to be reported by the next invocation
+func (c *Config) SaveExitLatency(command string, latency time.Duration) error {
+	if c.ExitLatencyFile == "" {
+		return nil
+	}
+	data, err := json.Marshal(exitLatency{Command: command, Latency: latency})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.ExitLatencyFile, data)
+}
+
+// TakeExitLatency returns the exit latency saved by the previous
+// invocation and removes it, so it's only reported once
+func (c *Config) TakeExitLatency() (command string, latency time.Duration, ok bool) {
+	data, err := os.ReadFile(c.ExitLatencyFile)
+	if err != nil {
+		return "", 0, false
+	}
+	os.Remove(c.ExitLatencyFile)
+
+	saved := exitLatency{}
+	if err := json.Unmarshal(data, &saved); err != nil || saved.Latency < 0 {
+		return "", 0, false
+	}
+	return saved.Command, saved.Latency, true
+}
+
+func getDefaultExitLatencyFilePath(cmdName string) string {
+	return getDefaultConsentFilePath(cmdName, defaultExitLatencyFilenamePostfix)
+}
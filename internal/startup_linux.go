@@ -0,0 +1,35 @@
+//go:build linux
+
+package internal
+
+import (
+	"os"
+	"time"
+)
+
+// Reads how long ago the process started from /proc, which is only
+// accurate to the clock tick. The boot time in /proc/stat is only to
+// the second, so the time since boot is taken from /proc/uptime instead.
+func readProcessStartTime() (time.Time, bool) {
+	stat, err := os.ReadFile("/proc/self/stat")
+	if err != nil {
+		return time.Time{}, false
+	}
+	ticks, ok := parseProcStatStartTicks(stat)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	current := time.Now()
+	data, err := os.ReadFile("/proc/uptime")
+	if err != nil {
+		return time.Time{}, false
+	}
+	uptime, ok := parseUptime(data)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	startedAfterBoot := time.Duration(ticks) * time.Second / clockTicksPerSecond
+	return current.Add(startedAfterBoot - uptime), true
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package internal
+
+import "time"
+
+// The process start time isn't read on this platform, so the package
+// init time is used instead
+func readProcessStartTime() (time.Time, bool) {
+	return time.Time{}, false
+}
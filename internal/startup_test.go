@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseProcStatStartTicks(t *testing.T) {
+	tests := []struct {
+		name     string
+		stat     string
+		expected uint64
+		ok       bool
+	}{
+		{
+			name:     "plain command name",
+			stat:     "1234 (my-cli) S 1 1234 1234 0 -1 4194560 100 0 0 0 1 2 0 0 20 0 8 0 56789 1000000 500",
+			expected: 56789,
+			ok:       true,
+		},
+		{
+			name:     "command name with spaces and parentheses",
+			stat:     "1234 (my cli) (x)) S 1 1234 1234 0 -1 4194560 100 0 0 0 1 2 0 0 20 0 8 0 42 1000000 500",
+			expected: 42,
+			ok:       true,
+		},
+		{
+			name: "truncated",
+			stat: "1234 (my-cli) S 1 1234",
+			ok:   false,
+		},
+		{
+			name: "no command name",
+			stat: "garbage",
+			ok:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseProcStatStartTicks([]byte(tt.stat))
+			if ok != tt.ok || got != tt.expected {
+				t.Errorf("parseProcStatStartTicks() = %v, %v, want %v, %v", got, ok, tt.expected, tt.ok)
+			}
+		})
+	}
+}
+
+func TestParseUptime(t *testing.T) {
+	tests := []struct {
+		uptime   string
+		expected time.Duration
+		ok       bool
+	}{
+		{uptime: "12345.67 98765.43\n", expected: 12345670 * time.Millisecond, ok: true},
+		{uptime: "", ok: false},
+		{uptime: "garbage 1.0", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.uptime, func(t *testing.T) {
+			got, ok := parseUptime([]byte(tt.uptime))
+			if diff := got - tt.expected; ok != tt.ok || diff < -time.Microsecond || diff > time.Microsecond {
+				t.Errorf("parseUptime() = %v, %v, want %v, %v", got, ok, tt.expected, tt.ok)
+			}
+		})
+	}
+}
+
+func TestProcessStartTime(t *testing.T) {
+	start := ProcessStartTime()
+	if start.After(initTime) {
+		t.Errorf("ProcessStartTime() = %v, want no later than package init %v", start, initTime)
+	}
+	if time.Since(start) > 24*time.Hour {
+		t.Errorf("ProcessStartTime() = %v, want the test process start", start)
+	}
+}
+
+func TestExitLatency(t *testing.T) {
+	config := &Config{ExitLatencyFile: filepath.Join(t.TempDir(), ".my-cli-metrics-exit-latency")}
+
+	if _, _, ok := config.TakeExitLatency(); ok {
+		t.Errorf("TakeExitLatency() ok = true before anything was saved, want false")
+	}
+
+	if err := config.SaveExitLatency("my-cli deploy", 250*time.Millisecond); err != nil {
+		t.Fatalf("SaveExitLatency() error = %v", err)
+	}
+
+	command, latency, ok := config.TakeExitLatency()
+	if !ok || command != "my-cli deploy" || latency != 250*time.Millisecond {
+		t.Errorf("TakeExitLatency() = %v, %v, %v, want my-cli deploy, 250ms, true", command, latency, ok)
+	}
+
+	if _, _, ok := config.TakeExitLatency(); ok {
+		t.Errorf("TakeExitLatency() ok = true on the second call, want false")
+	}
+}
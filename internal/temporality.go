@@ -0,0 +1,65 @@
+package internal
+
+import (
+	metricSdk "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// Temporality is the preferred temporality of the exported metrics
+type Temporality string
+
+const (
+	// Every instrument except up-down counters is exported as the change
+	// since the last export. This is the default.
+	TemporalityDelta Temporality = "delta"
+
+	// Every instrument is exported as the total since the process started,
+	// for backends which don't accept delta data
+	TemporalityCumulative Temporality = "cumulative"
+
+	// Synchronous counters and histograms are exported as deltas, while
+	// everything else is cumulative, so the SDK keeps as little state as
+	// possible between exports
+	TemporalityLowMemory Temporality = "low-memory"
+)
+
+// Returns the temporality selector for the reader, or nil if the
+// temporality is unknown. Unset means TemporalityDelta.
+func (t Temporality) selector() metricSdk.TemporalitySelector {
+	switch t {
+	case "", TemporalityDelta:
+		return deltaSelector
+	case TemporalityCumulative:
+		return metricSdk.DefaultTemporalitySelector
+	case TemporalityLowMemory:
+		return lowMemorySelector
+	default:
+		return nil
+	}
+}
+
+func deltaSelector(kind metricSdk.InstrumentKind) metricdata.Temporality {
+	switch kind {
+	case metricSdk.InstrumentKindCounter,
+		metricSdk.InstrumentKindGauge,
+		metricSdk.InstrumentKindHistogram,
+		metricSdk.InstrumentKindObservableGauge,
+		metricSdk.InstrumentKindObservableCounter:
+		return metricdata.DeltaTemporality
+	case metricSdk.InstrumentKindUpDownCounter,
+		metricSdk.InstrumentKindObservableUpDownCounter:
+		return metricdata.CumulativeTemporality
+	default:
+		return metricdata.CumulativeTemporality
+	}
+}
+
+func lowMemorySelector(kind metricSdk.InstrumentKind) metricdata.Temporality {
+	switch kind {
+	case metricSdk.InstrumentKindCounter,
+		metricSdk.InstrumentKindHistogram:
+		return metricdata.DeltaTemporality
+	default:
+		return metricdata.CumulativeTemporality
+	}
+}
@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"testing"
+
+	metricSdk "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestTemporalitySelector(t *testing.T) {
+	tests := []struct {
+		name        string
+		temporality Temporality
+		kind        metricSdk.InstrumentKind
+		expected    metricdata.Temporality
+	}{
+		{
+			name:        "unset counter",
+			temporality: "",
+			kind:        metricSdk.InstrumentKindCounter,
+			expected:    metricdata.DeltaTemporality,
+		},
+		{
+			name:        "delta counter",
+			temporality: TemporalityDelta,
+			kind:        metricSdk.InstrumentKindCounter,
+			expected:    metricdata.DeltaTemporality,
+		},
+		{
+			name:        "delta up down counter",
+			temporality: TemporalityDelta,
+			kind:        metricSdk.InstrumentKindUpDownCounter,
+			expected:    metricdata.CumulativeTemporality,
+		},
+		{
+			name:        "cumulative counter",
+			temporality: TemporalityCumulative,
+			kind:        metricSdk.InstrumentKindCounter,
+			expected:    metricdata.CumulativeTemporality,
+		},
+		{
+			name:        "cumulative histogram",
+			temporality: TemporalityCumulative,
+			kind:        metricSdk.InstrumentKindHistogram,
+			expected:    metricdata.CumulativeTemporality,
+		},
+		{
+			name:        "low memory histogram",
+			temporality: TemporalityLowMemory,
+			kind:        metricSdk.InstrumentKindHistogram,
+			expected:    metricdata.DeltaTemporality,
+		},
+		{
+			name:        "low memory observable counter",
+			temporality: TemporalityLowMemory,
+			kind:        metricSdk.InstrumentKindObservableCounter,
+			expected:    metricdata.CumulativeTemporality,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector := tt.temporality.selector()
+			if selector == nil {
+				t.Fatal("Expected selector but got nil")
+			}
+
+			if got := selector(tt.kind); got != tt.expected {
+				t.Errorf("selector(%v) = %v, want %v", tt.kind, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTemporalitySelectorUnknown(t *testing.T) {
+	if selector := Temporality("sometimes").selector(); selector != nil {
+		t.Error("Expected nil selector for unknown temporality")
+	}
+}
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"context"
+	"strings"
 
 	"go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/metric/metricdata"
@@ -28,3 +29,7 @@ func (m *mockExporter) Temporality(kind metric.InstrumentKind) metricdata.Tempor
 func (m *mockExporter) Aggregation(kind metric.InstrumentKind) metric.Aggregation {
 	return metric.DefaultAggregationSelector(kind)
 }
+
+func newTestReader(input string) *consentReader {
+	return newConsentReader(strings.NewReader(input))
+}
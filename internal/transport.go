@@ -0,0 +1,129 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+type commandContextKey struct{}
+
+// ContextWithCommand returns a copy of the context carrying the invoked
+// command, so work done with the command's context can be attributed to it
+func ContextWithCommand(ctx context.Context, cmd *cobra.Command) context.Context {
+	return context.WithValue(ctx, commandContextKey{}, cmd)
+}
+
+// CommandFromContext returns the invoked command carried by the context
+func CommandFromContext(ctx context.Context) (*cobra.Command, bool) {
+	cmd, ok := ctx.Value(commandContextKey{}).(*cobra.Command)
+	return cmd, ok && cmd != nil
+}
+
+// The methods recorded as is, anything else is recorded as _OTHER so
+// made up methods can't grow the number of series
+var knownMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// StatusClass returns the class of an HTTP status code, e.g. 2xx
+func StatusClass(code int) string {
+	if code < 100 || code > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(code/100) + "xx"
+}
+
+// transport records the requests made through it against the command
+// carried by the request's context
+type transport struct {
+	base     http.RoundTripper
+	provider func() *MetricsProvider
+}
+
+// NewTransport wraps the base transport, or http.DefaultTransport when nil,
+// to record client request metrics with the provider
+func NewTransport(base http.RoundTripper, provider func() *MetricsProvider) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{base: base, provider: provider}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	elapsed := time.Since(start)
+
+	// Only requests made with the command's context can be attributed
+	cmd, ok := CommandFromContext(req.Context())
+	mp := t.provider()
+	if !ok || mp == nil {
+		return resp, err
+	}
+
+	status := "error"
+	if err == nil {
+		status = StatusClass(resp.StatusCode)
+	}
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	if !knownMethods[method] {
+		method = "_OTHER"
+	}
+
+	// The URL's path and query can hold IDs and secrets, so only the
+	// host is ever recorded
+	config := mp.Config()
+	attributes := config.CommonAttributes(cmd)
+	attributes = append(attributes,
+		config.AttributeKey(AttributeCommand).String(ParseCmdName(cmd)),
+		config.AttributeKey(AttributeHost).String(req.URL.Hostname()),
+		config.AttributeKey(AttributeMethod).String(method),
+		config.AttributeKey(AttributeStatusClass).String(status),
+	)
+	t.record(req.Context(), cmd, mp, elapsed, attributes)
+
+	return resp, err
+}
+
+func (t *transport) record(ctx context.Context, cmd *cobra.Command, mp *MetricsProvider, elapsed time.Duration, attributes []attribute.KeyValue) {
+	config := mp.Config()
+	options := metric.WithAttributes(attributes...)
+
+	requests := config.MetricDefinition(cmd, MetricHTTPRequests)
+	counter, err := mp.GetMeter().Int64Counter(
+		requests.Name,
+		metric.WithDescription(requests.Description),
+		metric.WithUnit(requests.Unit),
+	)
+	if err == nil {
+		counter.Add(ctx, 1, options)
+	}
+
+	duration := config.MetricDefinition(cmd, MetricHTTPDuration)
+	histogram, err := mp.GetMeter().Float64Histogram(
+		duration.Name,
+		metric.WithDescription(duration.Description),
+		metric.WithUnit(duration.Unit),
+		metric.WithExplicitBucketBoundaries(HistogramBuckets(MetricHTTPDuration)...),
+	)
+	if err == nil {
+		histogram.Record(ctx, elapsed.Seconds(), options)
+	}
+}
@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestStatusClass(t *testing.T) {
+	tests := []struct {
+		code     int
+		expected string
+	}{
+		{code: 200, expected: "2xx"},
+		{code: 204, expected: "2xx"},
+		{code: 301, expected: "3xx"},
+		{code: 404, expected: "4xx"},
+		{code: 503, expected: "5xx"},
+		{code: 0, expected: "unknown"},
+		{code: 999, expected: "unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			if got := StatusClass(tt.code); got != tt.expected {
+				t.Errorf("StatusClass(%d) = %v, want %v", tt.code, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCommandFromContext(t *testing.T) {
+	if _, ok := CommandFromContext(context.Background()); ok {
+		t.Errorf("CommandFromContext() ok = true without a command, want false")
+	}
+
+	cmd := &cobra.Command{Use: "deploy"}
+	got, ok := CommandFromContext(ContextWithCommand(context.Background(), cmd))
+	if !ok || got != cmd {
+		t.Errorf("CommandFromContext() = %v, %v, want %v, true", got, ok, cmd)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	ctx := context.Background()
+	provider, err := NewMetricsProvider(ctx, &Config{ServiceName: "test-service"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer provider.Shutdown(ctx)
+
+	root := &cobra.Command{Use: "my-cli"}
+	deploy := &cobra.Command{Use: "deploy"}
+	root.AddCommand(deploy)
+
+	base := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Host == "down.example.com" {
+			return nil, errors.New("connection refused")
+		}
+		return &http.Response{StatusCode: http.StatusNotFound, Body: http.NoBody}, nil
+	})
+	client := &http.Client{Transport: NewTransport(base, func() *MetricsProvider { return provider })}
+
+	cmdCtx := ContextWithCommand(ctx, deploy)
+	requests := []struct {
+		ctx    context.Context
+		method string
+		url    string
+	}{
+		{ctx: cmdCtx, method: http.MethodGet, url: "https://api.example.com:8443/users/42?token=secret"},
+		{ctx: cmdCtx, method: "PURGE", url: "https://down.example.com/cache"},
+		{ctx: ctx, method: http.MethodGet, url: "https://api.example.com/unattributed"},
+	}
+	for _, r := range requests {
+		req, err := http.NewRequestWithContext(r.ctx, r.method, r.url, nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if resp, err := client.Do(req); err == nil {
+			resp.Body.Close()
+		}
+	}
+
+	sets := collectAttributes(t, provider.Reader)
+	expected := []attribute.Set{
+		attribute.NewSet(
+			attribute.String("command", "deploy"),
+			attribute.String("host", "api.example.com"),
+			attribute.String("method", "GET"),
+			attribute.String("status_class", "4xx"),
+		),
+		attribute.NewSet(
+			attribute.String("command", "deploy"),
+			attribute.String("host", "down.example.com"),
+			attribute.String("method", "_OTHER"),
+			attribute.String("status_class", "error"),
+		),
+	}
+
+	for _, name := range []string{"cli-my-cli-http-requests", "cli-my-cli-http-duration"} {
+		got := sets[name]
+		if len(got) != len(expected) {
+			t.Fatalf("Expected %d series for %s, got %d: %v", len(expected), name, len(got), got)
+		}
+		for _, want := range expected {
+			found := false
+			for _, set := range got {
+				if set.Equals(&want) {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("Expected %s series %v, got %v", name, want.Encoded(attribute.DefaultEncoder()), got)
+			}
+		}
+	}
+}
@@ -3,20 +3,117 @@ package metrics
 import (
 	"context"
 	"fmt"
-	"os"
-	"os/signal"
-	"syscall"
-	"time"
+	"net/http"
 
 	"github.com/iamkirkbater/cobra-otel-metrics/internal"
 	"github.com/spf13/cobra"
-	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
-	"go.opentelemetry.io/otel/sdk/metric/metricdata"
 )
 
 type Option = internal.Option
 
+// Inventory types describing every command and flag in a CLI
+type (
+	Inventory        = internal.Inventory
+	InventoryCommand = internal.InventoryCommand
+	InventoryFlag    = internal.InventoryFlag
+)
+
+// BuildInventory walks the whole command tree from the root command
+// and builds an inventory of every command and flag, so usage can be
+// joined against the full surface of the CLI
+var BuildInventory = internal.BuildInventory
+
+type ConsentMode = internal.ConsentMode
+
+const (
+	// Non-interactive sessions are opted in unless an opt-out file exists
+	ConsentModeDefault = internal.ConsentModeDefault
+
+	// Non-interactive sessions are opted out unless they explicitly opt in
+	ConsentModeStrict = internal.ConsentModeStrict
+)
+
+type Temporality = internal.Temporality
+
+const (
+	// Every instrument except up-down counters is exported as the change
+	// since the last export. This is the default.
+	TemporalityDelta = internal.TemporalityDelta
+
+	// Every instrument is exported as the total since the process started
+	TemporalityCumulative = internal.TemporalityCumulative
+
+	// Counters and histograms are exported as deltas, everything else
+	// is cumulative
+	TemporalityLowMemory = internal.TemporalityLowMemory
+)
+
+type NamingScheme = internal.NamingScheme
+
+const (
+	// Metrics are named cli-<root>-<metric> with bare attribute keys.
+	// This is the default.
+	NamingSchemeLegacy = internal.NamingSchemeLegacy
+
+	// Metrics and attributes follow the OTel naming guidance, e.g.
+	// cli.command.invocations with cli.name, cli.command.name and
+	// cli.flag.<name> attributes
+	NamingSchemeSemconv = internal.NamingSchemeSemconv
+)
+
+// Built-in metrics whose definitions can be replaced with
+// WithMetricDefinition
+type (
+	BuiltinMetric    = internal.BuiltinMetric
+	MetricDefinition = internal.MetricDefinition
+)
+
+const (
+	MetricInvocations     = internal.MetricInvocations
+	MetricDeprecatedUsage = internal.MetricDeprecatedUsage
+	MetricErrors          = internal.MetricErrors
+	MetricCompletions     = internal.MetricCompletions
+	MetricDuration        = internal.MetricDuration
+	MetricUserCPUTime     = internal.MetricUserCPUTime
+	MetricSystemCPUTime   = internal.MetricSystemCPUTime
+	MetricMaxRSS          = internal.MetricMaxRSS
+	MetricGCPauses        = internal.MetricGCPauses
+	MetricHeapAllocated   = internal.MetricHeapAllocated
+	MetricGCCycles        = internal.MetricGCCycles
+	MetricGoroutines      = internal.MetricGoroutines
+	MetricStartupLatency  = internal.MetricStartupLatency
+	MetricExitLatency     = internal.MetricExitLatency
+	MetricHTTPRequests    = internal.MetricHTTPRequests
+	MetricHTTPDuration    = internal.MetricHTTPDuration
+)
+
+// Annotations that can be set on any cobra.Command to control its
+// telemetry. These are inherited by subcommands.
+const (
+	// Set to "true" to never record telemetry for a command
+	AnnotationDisabled = internal.AnnotationDisabled
+
+	// Replaces the command's name in the reported command path
+	AnnotationName = internal.AnnotationName
+
+	// Prefix for extra invocation metric attributes, for example
+	// "otel.metrics/attr.team": "platform"
+	AnnotationAttributePrefix = internal.AnnotationAttributePrefix
+
+	// Set to "true" for commands that run for a long time, such as
+	// serve or watch, to export metrics periodically while they run
+	AnnotationLongRunning = internal.AnnotationLongRunning
+
+	// Set to "true" to serve the Prometheus /metrics endpoint while the
+	// command runs, when configured with WithPrometheusEndpoint
+	AnnotationPrometheus = internal.AnnotationPrometheus
+
+	// Set to a rate between 0 and 1 to sample the command's invocations
+	// at that rate instead of the configured sampling
+	AnnotationSampleRate = internal.AnnotationSampleRate
+)
+
 // Exposed Options
 var (
 	// Optional configuration to set the service name
@@ -25,6 +122,157 @@ var (
 
 	// Can be passed multiple times to add many exporters
 	WithExporter = internal.WithExporter
+
+	// Optional configuration to read consent prompt answers from
+	// somewhere other than the command's stdin
+	WithConsentInput = internal.WithConsentInput
+
+	// Optional configuration to write the consent prompt somewhere
+	// other than the command's stderr
+	WithConsentOutput = internal.WithConsentOutput
+
+	// Optional configuration for how long to wait for a consent answer
+	// before defaulting to "no" without saving. Zero waits forever.
+	WithConsentTimeout = internal.WithConsentTimeout
+
+	// Optional configuration for how many times an invalid consent
+	// answer is re-asked before defaulting to "no" without saving
+	WithConsentMaxRetries = internal.WithConsentMaxRetries
+
+	// Optional configuration for how consent is decided for
+	// non-interactive sessions, defaults to ConsentModeDefault
+	WithConsentMode = internal.WithConsentMode
+
+	// Optional configuration to change the environment variable that
+	// opts a session in under ConsentModeStrict, defaults to
+	// <ROOT>_METRICS_OPTIN
+	WithOptInEnvVar = internal.WithOptInEnvVar
+
+	// Optional configuration to name a boolean flag that opts a
+	// session in under ConsentModeStrict
+	WithOptInFlag = internal.WithOptInFlag
+
+	// Adds a persistent flag to the root command that disables
+	// telemetry for a single invocation. An empty name uses
+	// "no-telemetry".
+	WithNoTelemetryFlag = internal.WithNoTelemetryFlag
+
+	// Same as WithNoTelemetryFlag, but the flag is hidden from help
+	WithHiddenNoTelemetryFlag = internal.WithHiddenNoTelemetryFlag
+
+	// Records shell completion requests as their own metric, keyed
+	// by the command being completed. Without this, completion
+	// requests record and export nothing.
+	WithCompletionMetrics = internal.WithCompletionMetrics
+
+	// Adds a hidden command to the root command which prints an
+	// inventory of all commands and flags as JSON. An empty name
+	// uses "__metrics-inventory".
+	WithInventoryCommand = internal.WithInventoryCommand
+
+	// Adds a "session" attribute to every invocation, tying together
+	// the commands run in a single process such as a REPL. An empty
+	// id generates a random one.
+	WithSessionID = internal.WithSessionID
+
+	// Optional configuration for how often metrics are exported while
+	// a command marked with AnnotationLongRunning runs, defaults to 60s
+	WithExportInterval = internal.WithExportInterval
+
+	// Serves the metrics on a local /metrics endpoint in the Prometheus
+	// exposition format while commands marked with AnnotationPrometheus
+	// run. An empty address uses "localhost:9464".
+	WithPrometheusEndpoint = internal.WithPrometheusEndpoint
+
+	// Optional configuration for the temporality of exported metrics,
+	// defaults to TemporalityDelta
+	WithTemporality = internal.WithTemporality
+
+	// Optional configuration for the aggregation used for each kind of
+	// instrument, e.g. exponential histograms
+	WithAggregationSelector = internal.WithAggregationSelector
+
+	// Registers OTel Views on the provider to rename metrics, drop
+	// attributes or change histogram buckets
+	WithView = internal.WithView
+
+	// Optional configuration for how the built-in metrics and their
+	// attributes are named, defaults to NamingSchemeLegacy
+	WithNamingScheme = internal.WithNamingScheme
+
+	// Replaces the name, description or unit of a built-in metric,
+	// e.g. the invocation counter
+	WithMetricDefinition = internal.WithMetricDefinition
+
+	// Optional configuration for the maximum distinct attribute sets
+	// recorded per metric in a single execution, any more are folded
+	// into the otel.metric.overflow series. Defaults to 2000, zero
+	// means no limit.
+	WithCardinalityLimit = internal.WithCardinalityLimit
+
+	// Runs the processors in order over every attribute set recorded,
+	// built-in and custom, e.g. to drop keys or scrub values
+	WithAttributeProcessor = internal.WithAttributeProcessor
+
+	// Replaces the values of the attribute keys with a hash salted
+	// with a per-install salt
+	WithHashedAttributes = internal.WithHashedAttributes
+
+	// Samples invocations at a rate between 0 and 1, recording the rate
+	// as a sample_rate attribute so backends can re-weight counts
+	WithSampleRate = internal.WithSampleRate
+
+	// Samples at most limit invocations per window, counted across
+	// processes, recording the resulting rate as a sample_rate attribute
+	WithRateLimit = internal.WithRateLimit
+
+	// Merges every invocation's metrics into an on-disk aggregate, only
+	// exporting a rollup once the window has passed or the aggregate is
+	// over maxSize bytes. A maxSize of zero uses 1MiB.
+	WithLocalAggregation = internal.WithLocalAggregation
+
+	// Records the user and system CPU time, max RSS and wall time of every
+	// invocation as histograms tagged with the command
+	WithResourceMetrics = internal.WithResourceMetrics
+
+	// Records the GC pause time, heap allocated, GC cycles and goroutine
+	// high-water mark of every invocation as histograms tagged with the command
+	WithRuntimeMetrics = internal.WithRuntimeMetrics
+
+	// Records the time from process start to the command running, and from
+	// the command's Run completing to the process exiting, as histograms.
+	// Exit latency is reported by the next invocation.
+	WithStartupMetrics = internal.WithStartupMetrics
+)
+
+// AttributeProcessor rewrites every attribute set recorded before it
+// reaches the reader, see WithAttributeProcessor
+type (
+	AttributeProcessor = internal.AttributeProcessor
+	Scrubber           = internal.Scrubber
+)
+
+// Built in attribute processors
+var (
+	// Removes the keys from every attribute set
+	DropAttributes = internal.DropAttributes
+
+	// Replaces the parts of every string value that match the scrubbers,
+	// using DefaultScrubbers when none are given
+	ScrubAttributes = internal.ScrubAttributes
+
+	// Replaces the values of the keys with a hash salted with the given
+	// salt. WithHashedAttributes does the same with a per-install salt.
+	HashAttributes = internal.HashAttributes
+)
+
+// Scrubbers for values which commonly leak personal data
+var (
+	ScrubEmails      = internal.ScrubEmails
+	ScrubIPs         = internal.ScrubIPs
+	ScrubPaths       = internal.ScrubPaths
+	ScrubTokens      = internal.ScrubTokens
+	DefaultScrubbers = internal.DefaultScrubbers
 )
 
 // Extend the cobra.Command struct here to allow drop-in replacement
@@ -33,179 +281,95 @@ var (
 type Command struct {
 	cobra.Command
 
-	ctx context.Context
+	args      []string
+	telemetry *Telemetry
 }
 
 // SetupMetrics is a convenience function to set up metrics for a Cobra command
-// It initializes the metrics provider and sets up a cleanup handler
+// It initializes the metrics provider and sets up a cleanup handler.
+// The command can be executed any number of times until Shutdown is called.
 func (c *Command) SetupMetrics(options ...Option) error {
-	ctx := context.Background()
-
-	_, err := initialize(ctx, &c.Command, options...)
+	telemetry, err := Instrument(&c.Command, options...)
 	if err != nil {
-		return fmt.Errorf("failed to initialize metrics: %w", err)
+		return err
 	}
 
-	wrapPreRun(&c.Command, true)
-	wrapPostRun(&c.Command, true)
-
-	c.ctx = ctx
+	c.telemetry = telemetry
 	return nil
 }
 
-func wrapPreRun(cmd *cobra.Command, force bool) {
-	originalPreRunE := cmd.PersistentPreRunE
-	originalPreRun := cmd.PersistentPreRun
-	if originalPreRunE != nil || originalPreRun != nil || force {
-		cmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
-			// create the initial metric around the command called
-			err := internal.HandleMetricsOptIn(cmd)
-			if err != nil {
-				return err
-			}
-			err = createInvocationMetric(cmd)
-			if err != nil {
-				return err
-			}
-
-			// Run original PreRunE if it exists
-			if originalPreRunE != nil {
-				return originalPreRunE(cmd, args)
-			}
-			return nil
-		}
-	}
-}
-
-func wrapPostRun(cmd *cobra.Command, force bool) {
-	originalPostRunE := cmd.PersistentPostRunE
-	originalPostRun := cmd.PersistentPostRun
-	if originalPostRunE != nil || originalPostRun != nil || force {
-		cmd.PersistentPostRunE = func(cmd *cobra.Command, args []string) error {
-			var originalPostRunErr error
-			// Run original PostRunE if it exists
-			if originalPostRunE != nil {
-				if err := originalPostRunE(cmd, args); err != nil {
-					originalPostRunErr = err
-				}
-			}
-
-			// return the original error if it exists
-			return originalPostRunErr
-		}
-	}
-}
-
-func wrapSubCommandRunHooks(cmd *cobra.Command) {
-	wrapPreRun(cmd, false)
-	wrapPostRun(cmd, false)
-	wrapHelpFunc(cmd)
-	children := cmd.Commands()
-	for i := range children {
-		wrapSubCommandRunHooks(children[i])
-	}
-}
-
-func wrapHelpFunc(cmd *cobra.Command) {
-	oldHelpFunc := cmd.HelpFunc()
-	cmd.SetHelpFunc(func(cmd *cobra.Command, args []string) {
-		createInvocationMetric(cmd)
-		oldHelpFunc(cmd, args)
-	})
+// SetArgs sets the arguments for the command the same as
+// cobra.Command.SetArgs, while also keeping hold of them so
+// we can tell how the command was invoked
+func (c *Command) SetArgs(a []string) {
+	c.args = a
+	c.Command.SetArgs(a)
 }
 
+// Execute runs the command the same as cobra.Command.Execute, recording
+// metrics for the invocation and exporting them once it's done
 func (c *Command) Execute() error {
-	// catch trap signals and send metrics if we can
-	c.trap()
-
-	for _, command := range c.Command.Commands() {
-		wrapSubCommandRunHooks(command)
-	}
-
-	// Run the command
-	err := c.Command.Execute()
-
-	// push metrics even if the command wasn't successful
-	c.cleanup()
-
+	_, err := c.ExecuteC()
 	return err
 }
 
-func (c *Command) trap() {
-	// Trap Command Cancellations
-	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-ch
-		c.cleanup()
-		os.Exit(1)
-	}()
+// ExecuteContext is the same as Execute, but sets the ctx on the command
+func (c *Command) ExecuteContext(ctx context.Context) error {
+	_, err := c.ExecuteContextC(ctx)
+	return err
 }
 
-func (c *Command) cleanup() {
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
-	defer cancel()
-
-	// If the user has opted OUT of metric collection
-	// we just exit here. This allows the metrics to
-	// be collected as normal throughout the tool
-	// and transparent to the CLI maintainer if they
-	// are collecting additional metrics
-	if !internal.UserHasOptedInForMetrics {
-		return
-	}
-
-	collectedMetrics := &metricdata.ResourceMetrics{}
-	internal.Reader.Collect(c.ctx, collectedMetrics)
-
-	for _, exporter := range internal.Exporters {
-		err := exporter.Export(c.ctx, collectedMetrics)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "error exporting metrics: %v\n", err)
-		}
-	}
+// ExecuteContextC is the same as ExecuteC, but sets the ctx on the command
+func (c *Command) ExecuteContextC(ctx context.Context) (*cobra.Command, error) {
+	c.Command.SetContext(ctx)
+	return c.ExecuteC()
+}
 
-	if err := shutdown(shutdownCtx); err != nil {
-		fmt.Fprintf(os.Stderr, "Error shutting down metrics provider: %v\n", err)
+// ExecuteC runs the command the same as cobra.Command.ExecuteC, recording
+// metrics for the invocation and exporting them once it's done. Calling
+// any of the embedded cobra.Command's Execute functions directly skips
+// all instrumentation, as does not calling SetupMetrics first. The wrapped
+// hooks still call your own hooks, but never prompt or record.
+func (c *Command) ExecuteC() (*cobra.Command, error) {
+	if c.telemetry == nil {
+		return c.Command.ExecuteC()
 	}
+	return c.telemetry.executeC(c.args)
 }
 
-func createInvocationMetric(cmd *cobra.Command) error {
-	// Create a counter metric
-	metricName := "cli-" + internal.GetRootCmdName(cmd) + "-invocations"
-	counter, err := GetMeter().Int64Counter(
-		metricName,
-		metric.WithDescription("Command Invocation"),
-		metric.WithUnit("1"),
-	)
-	if err != nil {
-		return fmt.Errorf("failed to create counter: %w", err)
+// Shutdown exports anything left over and shuts down the metrics provider
+// and exporters. Metrics are exported after every execution, so this only
+// needs to be called once the process is completely done executing
+// commands, e.g. when a REPL exits.
+func (c *Command) Shutdown(ctx context.Context) error {
+	if c.telemetry == nil {
+		return nil
 	}
-
-	attributes := internal.ParseCmdFlagsToAttributes(cmd)
-	attributes = append(attributes, attribute.Bool("tty", internal.IsTTY()))
-	attributes = append(attributes, attribute.String("command", internal.ParseCmdName(cmd)))
-
-	attributeSet, _ := attribute.NewSetWithFiltered(attributes, nil)
-
-	counter.Add(context.Background(), 1, metric.WithAttributeSet(attributeSet))
-
-	return nil
+	return c.telemetry.Shutdown(ctx)
 }
 
 // Global metrics provider instance
 var globalProvider *internal.MetricsProvider
 
+// Transport wraps base, or http.DefaultTransport when nil, to record the
+// count, duration and status class of every request made with a command's
+// context, along with its host, method and the command. URLs and query
+// strings are never recorded.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return internal.NewTransport(base, func() *internal.MetricsProvider {
+		return globalProvider
+	})
+}
+
+// CommandFromContext returns the command being executed from its
+// context, as set before the command's hooks and Run are called
+var CommandFromContext = internal.CommandFromContext
+
 // GetMeter returns the meter for creating instruments
 func GetMeter() metric.Meter {
 	return globalProvider.GetMeter()
 }
 
-// shutdown gracefully shuts down the metrics provider
-func shutdown(ctx context.Context) error {
-	return globalProvider.Shutdown(ctx)
-}
-
 // initialize sets up the metrics provider with the given options
 func initialize(ctx context.Context, cmd *cobra.Command, options ...Option) (*internal.MetricsProvider, error) {
 	config, err := internal.NewConfig(cmd, options...)
@@ -0,0 +1,520 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/iamkirkbater/cobra-otel-metrics/internal"
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// Telemetry instruments an existing cobra command tree. It holds the
+// metrics provider and everything we know about the current execution.
+type Telemetry struct {
+	root     *cobra.Command
+	provider *internal.MetricsProvider
+	ctx      context.Context
+	args     []string
+
+	// Commands whose hooks have already been wrapped, so commands
+	// are never wrapped twice across repeated executions
+	wrapped map[*cobra.Command]bool
+
+	execution executionState
+	shutdown  sync.Once
+
+	// Guards what the signal handler reads of the execution, and
+	// serializes flushes, as long running commands flush in the
+	// background while they run
+	mu sync.Mutex
+
+	// The flag values from before the last execution, to put back
+	// before the next one
+	flags internal.FlagSnapshot
+
+	// Startup and exit latency are only reported by the first
+	// execution, as later ones in the same process didn't start
+	// it and the execution before them didn't exit it
+	executed bool
+}
+
+// executionState holds what we know about the current execution
+type executionState struct {
+	// Whether an instrumented execution is running. The wrapped hooks
+	// only record during one, so running the command through cobra
+	// directly skips all instrumentation.
+	active bool
+
+	// Whether this is the first execution in the process
+	first bool
+
+	// The raw args, used to tell which aliases and
+	// flag shorthands were used
+	args []string
+
+	// Whether the persistent pre-run hook has handled the invocation,
+	// and the command it was handled for
+	invocationHandled bool
+	invokedCmd        *cobra.Command
+
+	// The category of the first error that stopped the
+	// command before the persistent pre-run hook
+	errorCategory string
+
+	// Stops the periodic export of a long running command
+	stopPeriodicExport func()
+
+	// Serves the Prometheus endpoint while the command runs
+	prometheusServer *internal.PrometheusServer
+
+	// When the execution started and the resources the process had
+	// used by then, to record what the invocation used. startedAt is
+	// moved forward by however long the consent prompt took.
+	startedAt  time.Time
+	startUsage internal.ResourceUsage
+
+	// Reads the Go runtime's metrics over the execution
+	runtimeSampler *internal.RuntimeSampler
+
+	// Whether the signal handler already recorded what the
+	// interrupted execution used
+	interrupted bool
+
+	// When the persistent pre-run hook was reached, before any
+	// consent prompt, to measure the startup latency
+	preRunAt time.Time
+
+	// When the command's Run completed, to measure the exit latency
+	runFinishedAt time.Time
+}
+
+// Instrument sets up metrics for an existing root command without needing
+// to replace it with a metrics.Command. It applies the same hooks, help
+// wrapping and signal handling, and the returned Telemetry's Execute
+// functions should be used in place of the root command's.
+func Instrument(root *cobra.Command, options ...Option) (*Telemetry, error) {
+	ctx := context.Background()
+
+	provider, err := initialize(ctx, root, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize metrics: %w", err)
+	}
+
+	err = internal.AddNoTelemetryFlag(root, provider.Config())
+	if err != nil {
+		return nil, fmt.Errorf("failed to add no-telemetry flag: %w", err)
+	}
+
+	err = internal.AddInventoryCommand(root, provider.Config())
+	if err != nil {
+		return nil, fmt.Errorf("failed to add inventory command: %w", err)
+	}
+
+	t := &Telemetry{
+		root:     root,
+		provider: provider,
+		ctx:      ctx,
+		wrapped:  map[*cobra.Command]bool{},
+	}
+	t.wrapCommandTree(root)
+
+	return t, nil
+}
+
+// SetArgs sets the arguments for the root command the same as
+// cobra.Command.SetArgs, while also keeping hold of them so
+// we can tell how the command was invoked
+func (t *Telemetry) SetArgs(a []string) {
+	t.args = a
+	t.root.SetArgs(a)
+}
+
+// Execute runs the root command the same as cobra.Command.Execute,
+// recording metrics for the invocation and exporting them once it's done
+func (t *Telemetry) Execute() error {
+	_, err := t.ExecuteC()
+	return err
+}
+
+// ExecuteContext is the same as Execute, but sets the ctx on the command
+func (t *Telemetry) ExecuteContext(ctx context.Context) error {
+	_, err := t.ExecuteContextC(ctx)
+	return err
+}
+
+// ExecuteContextC is the same as ExecuteC, but sets the ctx on the command
+func (t *Telemetry) ExecuteContextC(ctx context.Context) (*cobra.Command, error) {
+	t.root.SetContext(ctx)
+	return t.ExecuteC()
+}
+
+// ExecuteC runs the root command the same as cobra.Command.ExecuteC,
+// recording metrics for the invocation and exporting them once it's done
+func (t *Telemetry) ExecuteC() (*cobra.Command, error) {
+	return t.executeC(t.args)
+}
+
+func (t *Telemetry) executeC(args []string) (*cobra.Command, error) {
+	t.execution = executionState{active: true, first: !t.executed, args: args, startedAt: time.Now()}
+	t.executed = true
+	t.execution.startUsage, _ = internal.ReadResourceUsage()
+	if t.provider.Config().RuntimeMetrics {
+		t.execution.runtimeSampler = internal.StartRuntimeSampler()
+	}
+	if t.execution.args == nil {
+		t.execution.args = os.Args[1:]
+	}
+	t.provider.ResetCardinalityLimit()
+
+	// Nothing is recorded until it's decided the execution is collected,
+	// so no reader aggregates an execution that's opted out or not sampled
+	t.provider.SetRecording(false)
+
+	// cobra adds these lazily during execution, so add them now
+	// to have them wrapped the same on every execution
+	t.root.InitDefaultHelpCmd()
+	t.root.InitDefaultCompletionCmd()
+	t.wrapCommandTree(t.root)
+
+	// cobra keeps flag values between executions, so flags from the
+	// last execution would be recorded again, and --no-telemetry
+	// would stick for every later execution. Only the flags it set
+	// are put back, so values set before executing are kept.
+	if t.flags != nil {
+		t.flags.Restore(t.root)
+	}
+	t.flags = internal.SnapshotFlags(t.root)
+
+	// catch trap signals and send metrics if we can
+	stopTrap := t.trap()
+
+	// Run the command
+	cmd, err := t.root.ExecuteC()
+	stopTrap()
+	t.execution.runFinishedAt = time.Now()
+
+	// Invocations that fail before the pre-run hook are
+	// never counted, so record why they failed instead
+	if err != nil && !t.execution.invocationHandled {
+		t.handleExecutionError(cmd, err)
+	}
+
+	if !t.execution.interrupted {
+		t.recordUsage(cmd)
+	}
+
+	t.stopLongRunningExport()
+	t.stopPrometheusEndpoint()
+
+	// push metrics even if the command wasn't successful
+	t.flush()
+	t.saveExitLatency()
+	t.execution.active = false
+
+	return cmd, err
+}
+
+// saveExitLatency persists the time since the command's Run completed,
+// for the next process to report. Every execution overwrites it, so the
+// last one before the process exits is what's reported, and it's saved
+// again on Shutdown so the final export is included.
+func (t *Telemetry) saveExitLatency() {
+	cmd := t.execution.invokedCmd
+	if !t.provider.Config().StartupMetrics || cmd == nil || t.execution.runFinishedAt.IsZero() {
+		return
+	}
+	if !internal.UserHasOptedInForMetrics || internal.IsCompletionRequest(cmd) {
+		return
+	}
+
+	latency := time.Since(t.execution.runFinishedAt)
+	if err := t.provider.Config().SaveExitLatency(internal.ParseCmdName(cmd), latency); err != nil {
+		fmt.Fprintf(os.Stderr, "error saving exit latency: %v\n", err)
+	}
+}
+
+// recordUsage records the resources and Go runtime metrics of the
+// invocation if they're enabled and metrics are collected for it
+func (t *Telemetry) recordUsage(cmd *cobra.Command) {
+	var stats internal.RuntimeStats
+	if t.execution.runtimeSampler != nil {
+		stats = t.execution.runtimeSampler.Stop()
+	}
+
+	if cmd == nil || !internal.UserHasOptedInForMetrics || internal.IsCompletionRequest(cmd) {
+		return
+	}
+	if t.provider.Config().ResourceMetrics {
+		if err := t.createResourceMetrics(cmd); err != nil {
+			fmt.Fprintf(os.Stderr, "error recording resource metrics: %v\n", err)
+		}
+	}
+	if t.execution.runtimeSampler != nil {
+		if err := t.createRuntimeMetrics(cmd, stats); err != nil {
+			fmt.Fprintf(os.Stderr, "error recording runtime metrics: %v\n", err)
+		}
+	}
+}
+
+// setErrorCategory keeps the category of the first error in the execution,
+// as errors from a flag error func or args validator are what stops it
+func (t *Telemetry) setErrorCategory(category string) {
+	if t.execution.active && t.execution.errorCategory == "" {
+		t.execution.errorCategory = category
+	}
+}
+
+// handleExecutionError decides if metrics are collected for an invocation
+// that failed before the pre-run hook, without prompting for consent,
+// and records the error metric
+func (t *Telemetry) handleExecutionError(cmd *cobra.Command, err error) {
+	if t.isTelemetryDisabled(cmd) {
+		internal.UserHasOptedInForMetrics = false
+		return
+	}
+
+	internal.HandleMetricsOptInWithoutPrompt(cmd, t.provider.Config())
+	if !t.sample(cmd) {
+		return
+	}
+
+	category := t.execution.errorCategory
+	if category == "" {
+		category = internal.CategorizeExecutionError(err)
+	}
+	if err := t.createErrorMetric(cmd, category); err != nil {
+		fmt.Fprintf(os.Stderr, "error recording error metric: %v\n", err)
+	}
+}
+
+// How long an interrupted command has to finish on its own before the
+// signal is raised again
+const interruptGracePeriod = 100 * time.Millisecond
+
+// trap flushes the metrics of an interrupted execution, then gives the
+// command a moment to finish on its own if it handles the signal itself,
+// before raising the signal again for the default action to stop the
+// process. It's only installed while a command executes, so a REPL's
+// prompt is left alone. The returned func removes it.
+func (t *Telemetry) trap() (stop func()) {
+	ch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer close(finished)
+		select {
+		case sig := <-ch:
+			signal.Stop(ch)
+			t.interrupt(sig, done)
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(ch)
+		close(done)
+		<-finished
+	}
+}
+
+// interrupt records and exports what the execution used up to the signal,
+// and raises it again unless the execution finishes in the meantime
+func (t *Telemetry) interrupt(sig os.Signal, done <-chan struct{}) {
+	t.mu.Lock()
+	t.execution.interrupted = true
+	t.recordUsage(t.execution.invokedCmd)
+	t.mu.Unlock()
+
+	t.flush()
+
+	select {
+	case <-done:
+		return
+	case <-time.After(interruptGracePeriod):
+	}
+
+	// Signals can't be raised on every platform, e.g. interrupts on Windows
+	process, err := os.FindProcess(os.Getpid())
+	if err != nil || process.Signal(sig) != nil {
+		os.Exit(1)
+	}
+}
+
+// startLongRunningExport flushes on the configured interval until the
+// execution is done, so long running commands export while they run
+// rather than only when they exit
+func (t *Telemetry) startLongRunningExport() {
+	if t.execution.stopPeriodicExport != nil {
+		return
+	}
+
+	interval := t.provider.Config().ExportInterval
+	if interval <= 0 {
+		interval = internal.DefaultExportInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-ticker.C:
+				t.flush()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	t.execution.stopPeriodicExport = func() {
+		ticker.Stop()
+		close(done)
+		<-stopped
+	}
+}
+
+// stopLongRunningExport stops the periodic export if one is running
+func (t *Telemetry) stopLongRunningExport() {
+	if t.execution.stopPeriodicExport != nil {
+		t.execution.stopPeriodicExport()
+		t.execution.stopPeriodicExport = nil
+	}
+}
+
+// startPrometheusEndpoint serves the Prometheus endpoint until the
+// execution is done. Failing to serve it never fails the command.
+func (t *Telemetry) startPrometheusEndpoint() {
+	if t.execution.prometheusServer != nil {
+		return
+	}
+
+	server, err := t.provider.ServePrometheus()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error serving prometheus metrics: %v\n", err)
+		return
+	}
+	t.execution.prometheusServer = server
+}
+
+// stopPrometheusEndpoint stops serving the Prometheus endpoint if it's served
+func (t *Telemetry) stopPrometheusEndpoint() {
+	if t.execution.prometheusServer == nil {
+		return
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := t.execution.prometheusServer.Shutdown(shutdownCtx); err != nil {
+		fmt.Fprintf(os.Stderr, "error shutting down prometheus metrics: %v\n", err)
+	}
+	t.execution.prometheusServer = nil
+}
+
+// flush collects everything recorded since the last flush and exports it.
+// Each execution flushes once it's done so every execution is exported
+// on its own, while the provider lives on for the next one.
+func (t *Telemetry) flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	collectedMetrics := &metricdata.ResourceMetrics{}
+	if err := t.provider.Reader.Collect(t.ctx, collectedMetrics); err != nil {
+		fmt.Fprintf(os.Stderr, "error collecting metrics: %v\n", err)
+		return
+	}
+
+	// If the user has opted OUT of metric collection, or the
+	// invocation wasn't sampled, we just exit here. The CLI
+	// maintainer's own instruments work as normal throughout the
+	// tool but record nothing for the invocation, and anything
+	// recorded before it was decided is dropped here so it isn't
+	// exported with a later execution.
+	if !internal.UserHasOptedInForMetrics {
+		return
+	}
+
+	// Nothing was recorded since the last flush
+	if len(collectedMetrics.ScopeMetrics) == 0 {
+		return
+	}
+
+	// With local aggregation the metrics are merged on disk and
+	// only exported as a rollup once it's due, unless there's
+	// nowhere on disk to keep the aggregate
+	if t.provider.Config().AggregationWindow > 0 && t.provider.Config().AggregateFile != "" {
+		unlock, err := t.provider.LockLocalAggregate()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error locking aggregated metrics: %v\n", err)
+			t.export(collectedMetrics)
+			return
+		}
+		defer unlock()
+
+		toExport, rolledUp, err := t.provider.AggregateLocally(collectedMetrics)
+		if err == nil {
+			// The aggregate is kept to try again next time
+			// unless every exporter succeeds
+			if t.export(toExport) && rolledUp {
+				if err := t.provider.ClearLocalAggregate(); err != nil {
+					fmt.Fprintf(os.Stderr, "error clearing aggregated metrics: %v\n", err)
+				}
+			}
+			return
+		}
+
+		// Export this execution on its own rather than lose it
+		fmt.Fprintf(os.Stderr, "error aggregating metrics: %v\n", err)
+	}
+
+	t.export(collectedMetrics)
+}
+
+// export sends the metrics to every exporter, reporting if they all succeeded
+func (t *Telemetry) export(metrics *metricdata.ResourceMetrics) bool {
+	if len(metrics.ScopeMetrics) == 0 {
+		return true
+	}
+
+	exported := true
+	for _, exporter := range internal.Exporters {
+		err := exporter.Export(t.ctx, metrics)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error exporting metrics: %v\n", err)
+			exported = false
+		}
+	}
+	return exported
+}
+
+// Shutdown exports anything left over and shuts down the metrics provider
+// and exporters. Metrics are exported after every execution, so this only
+// needs to be called once the process is completely done executing
+// commands, e.g. when a REPL exits. Only the first call has any effect.
+func (t *Telemetry) Shutdown(ctx context.Context) error {
+	var errs []error
+	t.shutdown.Do(func() {
+		t.flush()
+
+		if err := t.provider.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+		for _, exporter := range internal.Exporters {
+			if err := exporter.Shutdown(ctx); err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		t.saveExitLatency()
+	})
+	return errors.Join(errs...)
+}
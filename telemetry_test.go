@@ -0,0 +1,292 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/metric"
+)
+
+func TestRepeatedExecutions(t *testing.T) {
+	root := &cobra.Command{Use: "repl"}
+	sub := &cobra.Command{Use: "sub", Run: func(cmd *cobra.Command, args []string) {}}
+	sub.Flags().BoolP("verbose", "v", false, "verbose output")
+	root.AddCommand(sub)
+	root.SetIn(strings.NewReader(""))
+
+	exporter := &recordingExporter{}
+	telemetry, err := Instrument(root,
+		WithExporter(exporter),
+		WithNoTelemetryFlag(""),
+		WithSessionID(""),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer telemetry.Shutdown(context.Background())
+
+	executions := []struct {
+		args        []string
+		invocations int64
+		verbose     bool
+	}{
+		{args: []string{"sub", "-v"}, invocations: 1, verbose: true},
+		{args: []string{"sub"}, invocations: 1},
+		{args: []string{"sub", "--no-telemetry"}, invocations: 0},
+		{args: []string{"sub"}, invocations: 1},
+	}
+
+	sessions := map[string]bool{}
+	for i, execution := range executions {
+		exporter.mu.Lock()
+		exports := len(exporter.exports)
+		exporter.mu.Unlock()
+
+		telemetry.SetArgs(execution.args)
+		if err := telemetry.Execute(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		// every execution with anything to export exports on its own
+		exporter.mu.Lock()
+		exported := len(exporter.exports) - exports
+		exporter.mu.Unlock()
+		if want := int(execution.invocations); exported != want {
+			t.Errorf("execution %d exported %d times, want %d", i, exported, want)
+		}
+
+		points := exporter.takeCounter("cli-repl-invocations")
+		if got := sumPoints(points); got != execution.invocations {
+			t.Errorf("execution %d recorded %d invocations, want %d", i, got, execution.invocations)
+		}
+		for _, point := range points {
+			if _, verbose := attributeValue(point, "verbose"); verbose != execution.verbose {
+				t.Errorf("execution %d recorded the verbose flag = %v, want %v", i, verbose, execution.verbose)
+			}
+			session, _ := attributeValue(point, "session")
+			sessions[session] = true
+		}
+	}
+
+	if len(sessions) != 1 {
+		t.Errorf("Expected every execution to share one session, got %v", sessions)
+	}
+}
+
+func TestStartupMetricsOncePerProcess(t *testing.T) {
+	root := &cobra.Command{Use: "repl"}
+	root.AddCommand(&cobra.Command{Use: "sub", Run: func(cmd *cobra.Command, args []string) {}})
+	root.SetIn(strings.NewReader(""))
+
+	exporter := &recordingExporter{}
+	telemetry, err := Instrument(root,
+		WithExporter(exporter),
+		WithStartupMetrics(),
+		WithSessionID(""),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer telemetry.Shutdown(context.Background())
+
+	// the exit latency saved by the previous process
+	config := telemetry.provider.Config()
+	config.ExitLatencyFile = filepath.Join(t.TempDir(), ".repl-metrics-exit-latency")
+	if err := config.SaveExitLatency("previous", time.Second); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	executions := []struct {
+		startup int
+		exit    int
+	}{
+		{startup: 1, exit: 1},
+		{startup: 0, exit: 0},
+		{startup: 0, exit: 0},
+	}
+
+	for i, execution := range executions {
+		telemetry.SetArgs([]string{"sub"})
+		if err := telemetry.Execute(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		startup := exporter.histogramPoints("cli-repl-startup-latency")
+		if len(startup) != execution.startup {
+			t.Errorf("execution %d recorded %d startup latencies, want %d", i, len(startup), execution.startup)
+		}
+
+		exit := exporter.histogramPoints("cli-repl-exit-latency")
+		if len(exit) != execution.exit {
+			t.Errorf("execution %d recorded %d exit latencies, want %d", i, len(exit), execution.exit)
+		}
+		for _, point := range exit {
+			if command, _ := point.Attributes.Value("command"); command.AsString() != "previous" || point.Sum != 1 {
+				t.Errorf("execution %d recorded an exit latency of %v for %q, want 1 for %q", i, point.Sum, command.AsString(), "previous")
+			}
+		}
+
+		// every execution saves it, but only the next process reports it
+		if _, err := os.Stat(config.ExitLatencyFile); err != nil {
+			t.Errorf("execution %d didn't save its exit latency: %v", i, err)
+		}
+
+		exporter.mu.Lock()
+		exporter.exports = nil
+		exporter.mu.Unlock()
+	}
+}
+
+func TestFlagsSetBeforeExecuting(t *testing.T) {
+	var seen []string
+	root := &cobra.Command{Use: "repl"}
+	sub := &cobra.Command{Use: "sub", Run: func(cmd *cobra.Command, args []string) {
+		region, _ := cmd.Flags().GetString("region")
+		seen = append(seen, region)
+	}}
+	sub.Flags().String("region", "us-east-1", "a region")
+	root.AddCommand(sub)
+	root.SetIn(strings.NewReader(""))
+
+	telemetry, err := Instrument(root, WithExporter(&recordingExporter{}), WithSessionID(""))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer telemetry.Shutdown(context.Background())
+
+	if err := sub.Flags().Set("region", "eu-west-1"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, args := range [][]string{{"sub"}, {"sub", "--region", "ap-south-1"}, {"sub"}} {
+		telemetry.SetArgs(args)
+		if err := telemetry.Execute(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	want := []string{"eu-west-1", "ap-south-1", "eu-west-1"}
+	if !slices.Equal(seen, want) {
+		t.Errorf("executions saw regions %v, want %v", seen, want)
+	}
+}
+
+func TestUncollectedExecutionsAreNotScraped(t *testing.T) {
+	var telemetry *Telemetry
+	var counter metric.Int64Counter
+	var scraped string
+	root := &cobra.Command{Use: "repl"}
+	root.AddCommand(&cobra.Command{
+		Use:         "sub",
+		Annotations: map[string]string{AnnotationPrometheus: "true"},
+		Run: func(cmd *cobra.Command, args []string) {
+			counter.Add(cmd.Context(), 1)
+			if telemetry.execution.prometheusServer == nil {
+				return
+			}
+			resp, err := http.Get("http://" + telemetry.execution.prometheusServer.Addr() + "/metrics")
+			if err != nil {
+				t.Errorf("Unexpected error scraping metrics: %v", err)
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			scraped = string(body)
+		},
+	})
+	root.SetIn(strings.NewReader(""))
+
+	exporter := &recordingExporter{}
+	telemetry, err := Instrument(root,
+		WithExporter(exporter),
+		WithPrometheusEndpoint("127.0.0.1:0"),
+		WithNoTelemetryFlag(""),
+		WithSessionID(""),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer telemetry.Shutdown(context.Background())
+
+	counter, err = telemetry.provider.GetMeter().Int64Counter("custom")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, args := range [][]string{{"sub", "--no-telemetry"}, {"sub"}} {
+		telemetry.SetArgs(args)
+		if err := telemetry.Execute(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	// Only the collected execution is scraped, not the opted out one
+	// before it
+	var custom []string
+	for _, line := range strings.Split(scraped, "\n") {
+		if strings.HasPrefix(line, "custom_total") {
+			custom = append(custom, line)
+		}
+	}
+	if len(custom) != 1 || !strings.HasSuffix(custom[0], " 1") {
+		t.Errorf("scraped %v, want custom_total of 1", custom)
+	}
+	if got := sumPoints(exporter.takeCounter("custom")); got != 1 {
+		t.Errorf("exported custom = %d, want 1", got)
+	}
+}
+
+func TestInterruptedCommandFinishingOnItsOwn(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("interrupts can't be sent to the process on windows")
+	}
+
+	root := &cobra.Command{Use: "repl"}
+	root.AddCommand(&cobra.Command{Use: "serve", RunE: func(cmd *cobra.Command, args []string) error {
+		// a command shutting down gracefully on an interrupt
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		process, err := os.FindProcess(os.Getpid())
+		if err != nil {
+			return err
+		}
+		if err := process.Signal(os.Interrupt); err != nil {
+			return err
+		}
+		<-ctx.Done()
+		return nil
+	}})
+	root.SetIn(strings.NewReader(""))
+
+	exporter := &recordingExporter{}
+	telemetry, err := Instrument(root, WithExporter(exporter), WithResourceMetrics(), WithSessionID(""))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer telemetry.Shutdown(context.Background())
+
+	// the process is left running for the next execution
+	for i := 0; i < 2; i++ {
+		telemetry.SetArgs([]string{"serve"})
+		if err := telemetry.Execute(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if got := len(exporter.histogramPoints("cli-repl-duration")); got != 1 {
+			t.Errorf("execution %d recorded %d durations, want 1", i, got)
+		}
+		if got := sumPoints(exporter.takeCounter("cli-repl-invocations")); got != 1 {
+			t.Errorf("execution %d recorded %d invocations, want 1", i, got)
+		}
+	}
+}